@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// benchItems 读取测试预先写入的数据项数.
+const benchItems = 10000
+
+// BenchmarkSet 并发写入结果(SetReturn).
+func BenchmarkSet(b *testing.B) {
+	box := NewCache(nil)
+	value := []byte("result")
+	var seq uint64
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			key := "job-" + strconv.FormatUint(atomic.AddUint64(&seq, 1), 10)
+			box.Set(key, value, time.Minute*10, false)
+		}
+	})
+}
+
+// BenchmarkGet 并发读取结果(GetReturn).
+func BenchmarkGet(b *testing.B) {
+	box := NewCache(nil)
+	value := []byte("result")
+	for i := 0; i < benchItems; i++ {
+		box.Set("job-"+strconv.Itoa(i), value, time.Minute*10, false)
+	}
+	var seq uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n := atomic.AddUint64(&seq, 1)
+			box.Get("job-" + strconv.FormatUint(n%benchItems, 10))
+		}
+	})
+}
+
+// BenchmarkSetGet 并发写入后读取结果, 读写比例为1:1.
+func BenchmarkSetGet(b *testing.B) {
+	box := NewCache(nil)
+	value := []byte("result")
+	var seq uint64
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			key := "job-" + strconv.FormatUint(atomic.AddUint64(&seq, 1), 10)
+			box.Set(key, value, time.Minute*10, false)
+			box.Get(key)
+		}
+	})
+}
@@ -1,40 +1,83 @@
 package cache
 
 import (
+	"container/list"
+	"errors"
+	"io"
 	"time"
+
+	"../store"
 )
 
-// BlockSize 数据存储的数组大小.
+// BlockSize 数据分片数.
 const BlockSize = 100
 
 // BucketSize 数据分块存储的桶.
 const BucketSize = 10000
 
+// ErrFailed 任务执行失败, 返回的数据为失败原因.
+var ErrFailed = errors.New("failed")
+
 // Cache 缓存接口.
 type Cache interface {
-	// Set 向缓存中，添加一个值.
-	Set(key string, value []byte, lifespan time.Duration) error
+	// Set 向缓存中，添加一个值, lifespan为0时不过期, once为true时第一次读取后删除.
+	Set(key string, value []byte, lifespan time.Duration, once bool) error
 	// Get 获取一个值.
 	Get(key string) ([]byte, bool)
+	// Fail 记录任务失败原因，并通知订阅者.
+	Fail(key string, reason []byte, lifespan time.Duration, once bool) error
+	// Reason 获取任务失败原因.
+	Reason(key string) ([]byte, bool)
 	// Cover 将一个已经存在的值，覆盖.
 	Cover(key string, value []byte) (bool, error)
-	// GetAndTimeOut 获取一个值且有时间限制.
+	// GetAndTimeOut 获取一个值且有时间限制, 任务失败返回ErrFailed.
 	GetAndTimeOut(key string, time time.Duration, ch chan interface{}) ([]byte, error)
+	// Wait 等待一个值存在且有时间限制, 不读取数据, 任务失败返回ErrFailed.
+	Wait(key string, time time.Duration, ch chan interface{}) error
+	// WaitAny 等待多个值中任意一个存在且有时间限制, 返回第一个存在的key, 任务失败返回ErrFailed.
+	WaitAny(keys []string, time time.Duration, ch chan interface{}) (string, error)
+	// WaitAll 等待多个值全部存在且有时间限制, 超时后部分值可能不存在.
+	WaitAll(keys []string, time time.Duration, ch chan interface{}) error
+	// Open 打开一个值用于读取, 返回数据与数据大小.
+	Open(key string) (io.ReadCloser, int64, bool)
+	// SetFiles 设置大数据文件存储, 超过size字节的数据写入文件.
+	SetFiles(f *store.Files, size int)
+	// SetLimit 设置缓存容量限制, 超过限制时淘汰最久未使用的数据, 0不限制.
+	SetLimit(maxItems int, maxBytes int64)
+	// Stats 获取缓存统计信息.
+	Stats() Stats
 	// ClearAll 清空缓存.
 	ClearAll() error
-	// Delete 删除一个缓存.
-	Delete(key string) bool
+	// Delete 删除一个缓存, 返回缓存是否存在与日志写入错误.
+	Delete(key string) (bool, error)
 	// 定时回收数据.
 	StartAndGC() error
+	// SetJournal 设置日志, 缓存数据变化都会写入日志.
+	SetJournal(j Journal)
+	// Replay 重放一条日志记录.
+	Replay(rec [][]byte) error
+	// Snapshot 将当前未过期的缓存数据写入快照.
+	Snapshot(w Journal) error
+	// Recover 日志重放完成后删除没有缓存信息的存储数据.
+	Recover() error
 }
 
 // NewCache 新建一个缓存.
-// dur GC垃圾回收周期.
-func NewCache(dur time.Duration) Cache {
+// engine存储缓存数据, 为nil使用内存存储引擎.
+func NewCache(engine store.Engine) Cache {
+	if engine == nil {
+		engine = store.NewMemory()
+	}
 	c := &Block{
-		dur:      dur,
-		buckets:  make([]map[string]*Item, BlockSize),
-		channels: make(map[string]map[chan interface{}]interface{}, 0),
+		shards: make([]*shard, BlockSize),
+		lru:    list.New(),
+		engine: engine,
+	}
+	for i := range c.shards {
+		c.shards[i] = &shard{
+			items:    make(map[string]*Item, 10),
+			channels: make(map[string]map[chan interface{}]interface{}, 0),
+		}
 	}
 	c.StartAndGC()
 
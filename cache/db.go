@@ -1,229 +1,494 @@
 package cache
 
 import (
+	"container/list"
 	"errors"
+	"io/ioutil"
 	"sync"
 	"time"
 
 	"../h32"
+	"../store"
+	"../timer"
 )
 
 // Block 大盒子.
+// 数据按key分片存储, 每个分片有自己的锁, Block的锁只保护配置.
+// 所有分片的数据按最近使用的顺序保存在一个淘汰列表中, 淘汰列表有自己的锁.
+// 加锁顺序: 分片锁 -> 配置锁, 分片锁 -> 淘汰列表锁, 不能同时持有两个分片锁, 清空与恢复时按顺序锁定所有分片.
 type Block struct {
-	sync.RWMutex                                         // 数据锁.
-	dur      time.Duration                               // GC垃圾回收周期.
-	buckets  []map[string]*Item                          // 原始数据.
-	channels map[string]map[chan interface{}]interface{} // 注册事件, 用户订阅指定的keycache，当key数据存在时候，则通知订阅者.
+	items        int64        // 数据项数, 原子操作.
+	bytes        int64        // 内存中的数据字节数, 原子操作.
+	evictions    int64        // 淘汰的数据项数, 原子操作.
+	evictedBytes int64        // 淘汰的字节数, 原子操作.
+	keptItems    int64        // 不过期的数据项数, 原子操作.
+	keptBytes    int64        // 不过期的数据字节数, 原子操作.
+	sync.RWMutex              // 配置锁.
+	conf         config       // 配置.
+	shards       []*shard     // 数据分片.
+	lruMu        sync.Mutex   // 淘汰列表锁.
+	lru          *list.List   // 淘汰列表, 数据key, 最近使用的在头部, 最久未使用的在尾部.
+	engine       store.Engine // 数据存储引擎.
+	timer        *timer.Timer // 数据过期定时器.
 }
 
-// Item 数据存储项.
+// config 缓存配置.
+type config struct {
+	journal  Journal      // 数据变化日志.
+	files    *store.Files // 大数据文件存储, 为nil不写入文件.
+	spill    int          // 数据超过该字节数时写入文件.
+	maxItems int          // 最大数据项数, 0不限制.
+	maxBytes int64        // 最大字节数, 0不限制.
+}
+
+// shard 数据分片.
+type shard struct {
+	sync.RWMutex                                             // 分片锁.
+	items        map[string]*Item                            // 原始数据.
+	channels     map[string]map[chan interface{}]interface{} // 注册事件, 用户订阅指定的keycache，当key数据存在时候，则通知订阅者.
+}
+
+// Item 数据存储项, 数据保存在存储引擎或文件中.
 type Item struct {
-	value      []byte        // 数据.
 	createTime time.Time     // 创建时间.
-	lifespan   time.Duration // 生命周期.
+	lifespan   time.Duration // 生命周期, 0不过期.
+	once       bool          // 第一次读取后删除.
+	failed     bool          // 任务失败, 数据为失败原因.
+	ref        string        // 数据文件引用, 为空时数据保存在存储引擎中.
+	size       int64         // 占用内存的字节数.
+	elem       *list.Element // 在淘汰列表中的位置, 不过期的数据为nil.
 }
 
 // Set 添加一个值.
-func (box *Block) Set(key string, value []byte, lifespan time.Duration) error {
-	off := h32.DefaultHash.GetOffset(key, BlockSize, BucketSize)
-	box.Lock()
-	defer box.Unlock()
+func (box *Block) Set(key string, value []byte, lifespan time.Duration, once bool) error {
 
-	bucket := box.buckets[off]
-	if bucket == nil {
-		bucket = make(map[string]*Item, 10)
-		box.buckets[off] = bucket
-	}
-	if channels, ok := box.channels[key]; ok {
-		for channel := range channels {
-			channel <- nil
-		}
-		delete(box.channels, key)
-	}
+	return box.set(key, value, lifespan, once, false)
+}
+
+// Fail 记录任务失败原因，并通知订阅者.
+func (box *Block) Fail(key string, reason []byte, lifespan time.Duration, once bool) error {
+
+	return box.set(key, reason, lifespan, once, true)
+}
 
-	bucket[key] = &Item{
-		value:      value,
+// set 添加一个值，并通知订阅者.
+func (box *Block) set(key string, value []byte, lifespan time.Duration, once, failed bool) error {
+	conf := box.config()
+	ref, err := box.spillFile(conf, value)
+	if err != nil {
+
+		return err
+	}
+	itm := &Item{
 		createTime: time.Now(),
 		lifespan:   lifespan,
+		once:       once,
+		failed:     failed,
+		ref:        ref,
+		size:       itemSize(key, value, ref),
 	}
+	s := box.shard(key)
+	s.Lock()
+	// 日志写入成功后才保存数据并通知订阅者, 写入失败时数据不可见.
+	if err = box.record(conf, key, itm, value); err != nil {
+		box.release(conf, ref)
+		s.Unlock()
+
+		return err
+	}
+	if err = box.store(key, value, ref); err != nil {
+		box.unrecord(conf, s, key)
+		box.release(conf, ref)
+		s.Unlock()
+
+		return err
+	}
+	// 订阅者取消订阅前, 数据不会被淘汰.
+	s.notify(key)
+	box.add(conf, s, key, itm)
+	box.expireAt(key, itm)
+	s.Unlock()
+	box.evict(conf)
 
 	return nil
 }
 
 // Cover 覆盖一个值.
 func (box *Block) Cover(key string, value []byte) (bool, error) {
-	off := h32.DefaultHash.GetOffset(key, BlockSize, BucketSize)
-	box.Lock()
-	defer box.Unlock()
+	conf := box.config()
+	ref, err := box.spillFile(conf, value)
+	if err != nil {
 
-	if bucket := box.buckets[off]; bucket != nil {
-		if itm, ok := bucket[key]; ok {
-			itm.value = value
-			itm.createTime = time.Now()
+		return false, err
+	}
+	s := box.shard(key)
+	s.Lock()
+	if itm, ok := s.items[key]; ok {
+		// 日志写入成功后才覆盖数据, 写入失败时保留原数据.
+		next := *itm
+		next.ref = ref
+		next.createTime = time.Now()
+		if err = box.record(conf, key, &next, value); err != nil {
+			box.release(conf, ref)
+			s.Unlock()
+
+			return true, err
+		}
+		if err = box.store(key, value, ref); err != nil {
+			box.unrecord(conf, s, key)
+			box.release(conf, ref)
+			s.Unlock()
 
-			return true, nil
+			return true, err
 		}
+		box.release(conf, itm.ref)
+		itm.ref = ref
+		itm.createTime = next.createTime
+		box.expireAt(key, itm)
+		box.resize(itm, itemSize(key, value, ref))
+		box.touch(itm)
+		s.Unlock()
+		box.evict(conf)
+
+		return true, nil
 	}
-	delete(box.channels, key)
+	delete(s.channels, key)
+	box.release(conf, ref)
+	s.Unlock()
 
 	return false, nil
 }
 
 // Get 获取一个值.
 func (box *Block) Get(key string) ([]byte, bool) {
-	off := h32.DefaultHash.GetOffset(key, BlockSize, BucketSize)
-	box.Lock()
-	defer box.Unlock()
 
-	if bucket := box.buckets[off]; bucket != nil {
-		if itm, ok := bucket[key]; ok {
+	return box.read(key, false)
+}
+
+// Reason 获取任务失败原因.
+func (box *Block) Reason(key string) ([]byte, bool) {
 
-			return itm.value, ok
-		}
+	return box.read(key, true)
+}
+
+// read 读取一个值, failed为true时读取失败原因.
+func (box *Block) read(key string, failed bool) ([]byte, bool) {
+	value, fd, _, ok := box.lookup(key, failed)
+	if fd == nil {
+
+		return value, ok
 	}
+	defer fd.Close()
+	value, err := ioutil.ReadAll(fd)
 
-	return nil, false
+	return value, err == nil
 }
 
-// Delete 删除.
-func (box *Block) Delete(key string) bool {
-	off := h32.DefaultHash.GetOffset(key, BlockSize, BucketSize)
-	box.Lock()
-	defer box.Unlock()
+// exists 判定值是否存在, 以及任务是否失败.
+func (box *Block) exists(key string) (ok, failed bool) {
+	s := box.shard(key)
+	s.RLock()
+	defer s.RUnlock()
 
-	if bucket := box.buckets[off]; bucket != nil {
-		if _, ok := bucket[key]; ok {
-			delete(bucket, key)
+	if itm, ok := s.items[key]; ok {
 
-			return true
-		}
+		return true, itm.failed
 	}
-	delete(box.channels, key)
 
-	return false
+	return false, false
+}
+
+// Delete 删除, 返回数据是否存在与日志写入错误.
+func (box *Block) Delete(key string) (bool, error) {
+	conf := box.config()
+	s := box.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	if itm, ok := s.items[key]; ok {
+		box.del(conf, s, key, itm)
+
+		return true, box.recordDelete(conf, key)
+	}
+	delete(s.channels, key)
+
+	return false, nil
 }
 
 // GetAndTimeOut 获取值带有超时限制.
-func (box *Block) GetAndTimeOut(key string, timeout time.Duration, ch chan interface{}) (b []byte, err error) {
-	c := box.registerMessage(key)
-	b, ok := box.Get(key)
-	if err != nil || ok {
+func (box *Block) GetAndTimeOut(key string, timeout time.Duration, ch chan interface{}) ([]byte, error) {
+	err := box.Wait(key, timeout, ch)
+	if err == ErrFailed {
+		b, _ := box.Reason(key)
 
 		return b, err
 	}
+	if err != nil {
+
+		return nil, err
+	}
+	b, _ := box.Get(key)
+
+	return b, nil
+}
+
+// Wait 等待值存在且有时间限制, 不读取数据.
+func (box *Block) Wait(key string, timeout time.Duration, ch chan interface{}) (err error) {
+	c := box.registerMessage(key)
+	defer box.deregisterMessage(key, c)
+
+	if ok, failed := box.exists(key); ok {
+		if failed {
+
+			return ErrFailed
+		}
+
+		return nil
+	}
 
 	tick := time.NewTicker(timeout)
 	defer tick.Stop()
 	select {
 	case <-c:
-		b, ok = box.Get(key)
+		if _, failed := box.exists(key); failed {
+			err = ErrFailed
+		}
 	case <-ch:
 		err = errors.New("EOF")
 	case <-tick.C:
 		err = errors.New("timeout")
 	}
-	box.deregisterMessage(key, c)
 
 	return
 }
 
+// WaitAny 等待多个值中任意一个存在且有时间限制, 返回第一个存在的key, 不读取数据, 任务失败返回ErrFailed.
+func (box *Block) WaitAny(keys []string, timeout time.Duration, ch chan interface{}) (string, error) {
+	var found string
+	var failed bool
+	err := box.waitKeys(keys, timeout, ch, func() bool {
+		for _, key := range keys {
+			var ok bool
+			if ok, failed = box.exists(key); ok {
+				found = key
+
+				return true
+			}
+		}
+
+		return false
+	})
+	if err == nil && failed {
+		err = ErrFailed
+	}
+
+	return found, err
+}
+
+// WaitAll 等待多个值全部存在且有时间限制, 不读取数据, 超时后部分值可能不存在.
+func (box *Block) WaitAll(keys []string, timeout time.Duration, ch chan interface{}) error {
+
+	return box.waitKeys(keys, timeout, ch, func() bool {
+		for _, key := range keys {
+			if ok, _ := box.exists(key); !ok {
+
+				return false
+			}
+		}
+
+		return true
+	})
+}
+
+// waitKeys 多个key共用一个通信对象, 任意一个key有数据时检查done, done返回true时结束等待.
+func (box *Block) waitKeys(keys []string, timeout time.Duration, ch chan interface{}, done func() bool) error {
+	c := make(chan interface{}, 1)
+	for _, key := range keys {
+		box.register(key, c)
+	}
+	defer func() {
+		for _, key := range keys {
+			box.deregisterMessage(key, c)
+		}
+	}()
+
+	tick := time.NewTimer(timeout)
+	defer tick.Stop()
+	for !done() {
+		select {
+		case <-c:
+		case <-ch:
+			return errors.New("EOF")
+		case <-tick.C:
+			return errors.New("timeout")
+		}
+	}
+
+	return nil
+}
+
 // registerMessage 获取一个通信对象.
 func (box *Block) registerMessage(key string) chan interface{} {
 	c := make(chan interface{}, 2)
-	box.Lock()
-	defer box.Unlock()
+	box.register(key, c)
+
+	return c
+}
+
+// register 订阅key, 数据存在时通知通信对象.
+func (box *Block) register(key string, c chan interface{}) {
+	s := box.shard(key)
+	s.Lock()
+	defer s.Unlock()
 
-	channels, ok := box.channels[key]
+	channels, ok := s.channels[key]
 	if !ok {
 		channels = make(map[chan interface{}]interface{}, 2)
-		box.channels[key] = channels
+		s.channels[key] = channels
 	}
 	channels[c] = nil
+}
 
-	return c
+// notify 通知订阅者数据已经存在, 不删除订阅, 调用方需要持有分片锁.
+func (s *shard) notify(key string) {
+	for channel := range s.channels[key] {
+		select {
+		case channel <- nil:
+		default:
+		}
+	}
 }
 
 // deregisterMessage 删除一个通信对象.
 func (box *Block) deregisterMessage(key string, c chan interface{}) error {
-	box.Lock()
-	defer box.Unlock()
+	s := box.shard(key)
+	s.Lock()
+	defer s.Unlock()
 
-	if channels, ok := box.channels[key]; ok {
+	if channels, ok := s.channels[key]; ok {
 		delete(channels, c)
 		// 空数据删除对象.
 		if len(channels) < 1 {
-			delete(box.channels, key)
+			delete(s.channels, key)
 		}
 	}
 
 	return nil
 }
 
-// StartAndGC 开启垃圾回收.
+// StartAndGC 开启垃圾回收, 数据到期时删除.
 func (box *Block) StartAndGC() error {
-	go box.vaccuum()
+	box.Lock()
+	defer box.Unlock()
+
+	if box.timer == nil {
+		box.timer = timer.New(box.expire)
+	}
 
 	return nil
 }
 
 // ClearAll 清空缓存.
 func (box *Block) ClearAll() error {
-	box.Lock()
-	defer box.Unlock()
+	conf := box.config()
+	box.lockAll()
+	defer box.unlockAll()
 
-	for _, channels := range box.channels {
-		for channel := range channels {
-			channel <- nil
+	for _, s := range box.shards {
+		for key := range s.channels {
+			s.notify(key)
 		}
+		s.channels = make(map[string]map[chan interface{}]interface{}, 0)
+	}
+	box.clear(conf)
+	if conf.journal != nil {
+		conf.journal.Append([]byte("Clear"))
 	}
-	box.buckets = make([]map[string]*Item, BlockSize)
-	box.channels = make(map[string]map[chan interface{}]interface{}, 0)
 
 	return nil
 }
 
-// vaccuum 垃圾回收.
-func (box *Block) vaccuum() {
-	tick := time.Tick(box.dur)
-	for {
-		<-tick
-		if box.buckets == nil {
-			continue
+// clear 清空数据, 调用方需要持有所有分片锁.
+func (box *Block) clear(conf config) {
+	for _, s := range box.shards {
+		for key, itm := range s.items {
+			box.del(conf, s, key, itm)
 		}
+	}
+}
 
-		for _, bucket := range box.buckets {
-			for key := range bucket {
-				box.itemExpired(key)
-			}
-		}
+// lockAll 按顺序锁定所有分片.
+func (box *Block) lockAll() {
+	for _, s := range box.shards {
+		s.Lock()
+	}
+}
+
+// unlockAll 解锁所有分片.
+func (box *Block) unlockAll() {
+	for _, s := range box.shards {
+		s.Unlock()
+	}
+}
+
+// expireAt 添加数据过期定时项.
+func (box *Block) expireAt(key string, itm *Item) {
+	if itm.lifespan > 0 {
+		box.timer.Add(key, 0, itm.createTime.Add(itm.lifespan))
 	}
 }
 
+// expire 定时项到期, 数据被覆盖后定时项失效.
+func (box *Block) expire(t *timer.Item) {
+	box.itemExpired(t.Key)
+}
+
 // itemExpired 处理数据是否被回收.
 func (box *Block) itemExpired(key string) bool {
-	off := h32.DefaultHash.GetOffset(key, BlockSize, BucketSize)
-	box.Lock()
-	defer box.Unlock()
+	conf := box.config()
+	s := box.shard(key)
+	s.Lock()
+	defer s.Unlock()
 
-	if bucket := box.buckets[off]; bucket != nil {
-		if itm, ok := bucket[key]; ok {
-			if itm.isExpire() {
-				delete(bucket, key)
-				if channels, ok := box.channels[key]; ok {
-					for channel := range channels {
-						channel <- nil
-					}
-					delete(box.channels, key)
-				}
+	if itm, ok := s.items[key]; ok {
+		if itm.isExpire() {
+			box.del(conf, s, key, itm)
+			s.notify(key)
+			delete(s.channels, key)
 
-				return true
-			}
+			return true
 		}
 	}
 
 	return false
 }
 
+// config 获取配置.
+func (box *Block) config() config {
+	box.RLock()
+	defer box.RUnlock()
+
+	return box.conf
+}
+
+// offset 获取key所在的分片序号.
+func (box *Block) offset(key string) int {
+
+	return int(h32.DefaultHash.GetOffset(key, BlockSize, BucketSize))
+}
+
+// shard 获取key所在的分片.
+func (box *Block) shard(key string) *shard {
+
+	return box.shards[box.offset(key)]
+}
+
+// kept 判定数据是否不过期, 不过期的数据保留到被删除, 不会被淘汰.
+func (itm *Item) kept() bool {
+
+	return itm.lifespan == 0
+}
+
 // isExpire 判定数据是否过期.
 func (itm *Item) isExpire() bool {
 	if itm.lifespan == 0 {
@@ -231,5 +496,5 @@ func (itm *Item) isExpire() bool {
 		return false
 	}
 
-	return time.Now().Sub(itm.createTime) > itm.lifespan
+	return time.Now().Sub(itm.createTime) >= itm.lifespan
 }
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"../store"
+)
+
+// SetFiles 设置大数据文件存储, 超过size字节的数据写入文件, 缓存中只保存文件引用.
+func (box *Block) SetFiles(f *store.Files, size int) {
+	box.Lock()
+	defer box.Unlock()
+
+	box.conf.files = f
+	box.conf.spill = size
+}
+
+// Open 打开一个值用于读取, 返回数据与数据大小, 写入文件的数据直接读取文件.
+func (box *Block) Open(key string) (io.ReadCloser, int64, bool) {
+	value, fd, size, ok := box.lookup(key, false)
+	if !ok {
+
+		return nil, 0, false
+	}
+	if fd != nil {
+
+		return fd, size, true
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(value)), size, true
+}
+
+// lookup 查找一个值, 数据写入文件时返回打开的文件, 由调用方关闭.
+// 第一次读取后删除的数据在读取后删除, 已经打开的文件在关闭前仍然可以读取.
+func (box *Block) lookup(key string, failed bool) ([]byte, *os.File, int64, bool) {
+	conf := box.config()
+	s := box.shard(key)
+	s.RLock()
+	itm, ok := s.items[key]
+	if ok && itm.once {
+		// 读取后需要删除, 重新获取分片写锁.
+		s.RUnlock()
+		s.Lock()
+		defer s.Unlock()
+		itm, ok = s.items[key]
+	} else {
+		defer s.RUnlock()
+	}
+	if !ok || itm.failed != failed {
+
+		return nil, nil, 0, false
+	}
+
+	box.touch(itm)
+	value, fd, size, ok := box.open(conf, key, itm)
+	if ok && itm.once {
+		box.del(conf, s, key, itm)
+		// 日志写入失败时重启后数据恢复, 最多多读取一次.
+		box.recordDelete(conf, key)
+	}
+
+	return value, fd, size, ok
+}
+
+// open 读取数据项的数据, 写入文件的数据返回打开的文件, 调用方需要持有分片锁.
+func (box *Block) open(conf config, key string, itm *Item) ([]byte, *os.File, int64, bool) {
+	if itm.ref == "" {
+		value, ok := box.engine.Get(key)
+
+		return value, nil, int64(len(value)), ok
+	}
+	// 文件在关闭前仍然可以读取, 不受数据过期删除影响.
+	fd, size, err := conf.files.Open(itm.ref)
+	if err != nil {
+
+		return nil, nil, 0, false
+	}
+
+	return nil, fd, size, true
+}
+
+// spillFile 数据超过限制时写入文件, 返回文件引用, 不需要写入文件时返回空.
+func (box *Block) spillFile(conf config, value []byte) (string, error) {
+	if conf.files == nil || len(value) <= conf.spill {
+
+		return "", nil
+	}
+
+	return conf.files.Put(value)
+}
+
+// store 保存数据, ref不为空时数据已经写入文件, 调用方需要持有分片锁.
+func (box *Block) store(key string, value []byte, ref string) error {
+	if ref != "" {
+
+		return box.engine.Delete(key)
+	}
+
+	return box.engine.Set(key, value)
+}
+
+// remove 删除数据与数据文件, 调用方需要持有分片锁.
+func (box *Block) remove(conf config, key string, itm *Item) {
+	box.engine.Delete(key)
+	box.release(conf, itm.ref)
+}
+
+// release 释放数据文件引用.
+func (box *Block) release(conf config, ref string) {
+	if ref != "" && conf.files != nil {
+		conf.files.Release(ref)
+	}
+}
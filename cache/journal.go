@@ -0,0 +1,226 @@
+package cache
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ErrJournal 日志记录格式错误.
+var ErrJournal = errors.New("journal record error")
+
+// Journal 缓存数据变化的追加写日志.
+type Journal interface {
+	// Append 追加一条记录.
+	Append(args ...[]byte) error
+}
+
+// SetJournal 设置日志, 缓存数据变化都会写入日志.
+func (box *Block) SetJournal(j Journal) {
+	box.Lock()
+	defer box.Unlock()
+
+	box.conf.journal = j
+}
+
+// record 记录缓存数据变化, 返回日志写入错误, 调用方需要持有分片锁.
+func (box *Block) record(conf config, key string, itm *Item, value []byte) error {
+	if conf.journal == nil {
+		return nil
+	}
+
+	return conf.journal.Append(itm.record(key, value)...)
+}
+
+// record 缓存日志记录, 数据写入文件时value为空.
+// 格式: Set key value createTime lifespan failed ref once.
+func (itm *Item) record(key string, value []byte) [][]byte {
+	failed := "0"
+	if itm.failed {
+		failed = "1"
+	}
+
+	once := "0"
+	if itm.once {
+		once = "1"
+	}
+
+	if itm.ref != "" {
+		value = nil
+	}
+
+	return [][]byte{
+		[]byte("Set"),
+		[]byte(key),
+		value,
+		[]byte(strconv.FormatInt(itm.createTime.UnixNano(), 10)),
+		[]byte(strconv.FormatInt(int64(itm.lifespan), 10)),
+		[]byte(failed),
+		[]byte(itm.ref),
+		[]byte(once),
+	}
+}
+
+// unrecord 日志写入后保存数据失败, 重新记录原数据, 没有原数据时记录删除, 调用方需要持有分片锁.
+func (box *Block) unrecord(conf config, s *shard, key string) {
+	if old, ok := s.items[key]; ok {
+		value, _ := box.engine.Get(key)
+		box.record(conf, key, old, value)
+
+		return
+	}
+	box.recordDelete(conf, key)
+}
+
+// recordDelete 记录删除缓存, 返回日志写入错误, 调用方需要持有分片锁.
+func (box *Block) recordDelete(conf config, key string) error {
+	if conf.journal == nil {
+		return nil
+	}
+
+	return conf.journal.Append([]byte("Delete"), []byte(key))
+}
+
+// Replay 重放一条日志记录, 已经过期的数据不恢复.
+func (box *Block) Replay(rec [][]byte) error {
+	conf := box.config()
+	if len(rec) < 1 {
+		return ErrJournal
+	}
+
+	switch string(rec[0]) {
+	case "Set":
+		if len(rec) < 6 {
+			return ErrJournal
+		}
+		createTime, err := strconv.ParseInt(string(rec[3]), 10, 64)
+		if err != nil {
+			return ErrJournal
+		}
+		lifespan, err := strconv.ParseInt(string(rec[4]), 10, 64)
+		if err != nil {
+			return ErrJournal
+		}
+		itm := &Item{
+			createTime: time.Unix(0, createTime),
+			lifespan:   time.Duration(lifespan),
+			failed:     string(rec[5]) == "1",
+		}
+		if len(rec) > 6 {
+			itm.ref = string(rec[6])
+		}
+		if len(rec) > 7 {
+			itm.once = string(rec[7]) == "1"
+		}
+		key := string(rec[1])
+		itm.size = itemSize(key, rec[2], itm.ref)
+		s := box.shard(key)
+		s.Lock()
+		defer s.Unlock()
+
+		// 文件引用计数在Recover时重建.
+		if itm.isExpire() {
+			if old, ok := s.items[key]; ok {
+				box.del(conf, s, key, old)
+			}
+		} else if err := box.store(key, rec[2], itm.ref); err != nil {
+			return err
+		} else {
+			box.add(conf, s, key, itm)
+			box.expireAt(key, itm)
+		}
+	case "Delete":
+		if len(rec) < 2 {
+			return ErrJournal
+		}
+		key := string(rec[1])
+		s := box.shard(key)
+		s.Lock()
+		defer s.Unlock()
+
+		if itm, ok := s.items[key]; ok {
+			box.del(conf, s, key, itm)
+		}
+	case "Clear":
+		box.lockAll()
+		defer box.unlockAll()
+
+		box.clear(conf)
+	default:
+		return ErrJournal
+	}
+
+	return nil
+}
+
+// Snapshot 将当前未过期的缓存数据写入快照.
+func (box *Block) Snapshot(w Journal) error {
+	for _, s := range box.shards {
+		s.RLock()
+		items := make(map[string]Item, len(s.items))
+		for key, itm := range s.items {
+			if !itm.isExpire() {
+				// 只复制日志记录需要的字段.
+				items[key] = Item{
+					createTime: itm.createTime,
+					lifespan:   itm.lifespan,
+					once:       itm.once,
+					failed:     itm.failed,
+					ref:        itm.ref,
+				}
+			}
+		}
+		s.RUnlock()
+
+		for key, itm := range items {
+			value, ok := box.engine.Get(key)
+			if !ok && itm.ref == "" {
+				continue
+			}
+			if err := w.Append(itm.record(key, value)...); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Recover 日志重放完成后删除没有缓存信息的存储数据, 重建数据文件引用计数并删除没有引用的文件.
+func (box *Block) Recover() error {
+	conf := box.config()
+	box.lockAll()
+	err := box.engine.Range(func(key string) bool {
+		if _, ok := box.shard(key).items[key]; !ok {
+			box.engine.Delete(key)
+		}
+
+		return true
+	})
+	if err != nil {
+		box.unlockAll()
+		return err
+	}
+
+	refs := make(map[string]int)
+	for _, s := range box.shards {
+		for key, itm := range s.items {
+			if itm.ref == "" {
+				continue
+			}
+			if conf.files == nil {
+				// 没有文件存储, 数据已经丢失.
+				box.del(conf, s, key, itm)
+				continue
+			}
+			refs[itm.ref]++
+		}
+	}
+	if conf.files != nil {
+		err = conf.files.Reset(refs)
+	}
+	box.unlockAll()
+	box.evict(conf)
+
+	return err
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// errDisk 测试使用的日志写入错误.
+var errDisk = errors.New("disk full")
+
+// records 保存在内存中的日志, err不为空时写入失败.
+type records struct {
+	sync.Mutex
+	recs [][][]byte
+	err  error
+}
+
+// Append 追加一条记录.
+func (r *records) Append(args ...[]byte) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.err != nil {
+
+		return r.err
+	}
+	r.recs = append(r.recs, args)
+
+	return nil
+}
+
+// TestSnapshotWhileReading 生成快照时并发读取数据, 使用-race检查数据竞争.
+func TestSnapshotWhileReading(t *testing.T) {
+	box := NewCache(nil)
+	for i := 0; i < 100; i++ {
+		box.Set("job-"+strconv.Itoa(i), []byte("result"), time.Minute, false)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for n := 0; n < 4; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				box.Get("job-" + strconv.Itoa(i%100))
+			}
+		}()
+	}
+	for n := 0; n < 20; n++ {
+		w := &records{}
+		if err := box.Snapshot(w); err != nil {
+			t.Fatal(err)
+		}
+		if len(w.recs) != 100 {
+			t.Fatalf("snapshot records = %d, want 100", len(w.recs))
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+// TestJournalError 日志写入失败时返回错误.
+func TestJournalError(t *testing.T) {
+	box := NewCache(nil)
+	box.Set("job-1", []byte("result"), time.Minute, false)
+	box.SetJournal(&records{err: errDisk})
+
+	if err := box.Set("job-2", []byte("result"), time.Minute, false); err != errDisk {
+		t.Errorf("Set err = %v, want %v", err, errDisk)
+	}
+	if err := box.Fail("job-3", []byte("reason"), time.Minute, false); err != errDisk {
+		t.Errorf("Fail err = %v, want %v", err, errDisk)
+	}
+	if ok, err := box.Cover("job-1", []byte("cover")); !ok || err != errDisk {
+		t.Errorf("Cover = %v, %v, want true, %v", ok, err, errDisk)
+	}
+	if value, _ := box.Get("job-1"); string(value) != "result" {
+		t.Errorf("value after failed Cover = %q, want result", value)
+	}
+	if ok, err := box.Delete("job-1"); !ok || err != errDisk {
+		t.Errorf("Delete = %v, %v, want true, %v", ok, err, errDisk)
+	}
+	if ok, err := box.Delete("job-9"); ok || err != nil {
+		t.Errorf("Delete missing = %v, %v, want false, nil", ok, err)
+	}
+}
+
+// TestSetJournalErrorInvisible 日志写入失败的结果不可见, 也不会唤醒等待的订阅者.
+func TestSetJournalErrorInvisible(t *testing.T) {
+	box := NewCache(nil)
+	box.SetJournal(&records{err: errDisk})
+	done := make(chan error)
+	go func() {
+		done <- box.Wait("job", 50*time.Millisecond, nil)
+	}()
+	// 等待订阅完成.
+	time.Sleep(10 * time.Millisecond)
+
+	if err := box.Set("job", []byte("result"), time.Minute, false); err != errDisk {
+		t.Fatalf("Set err = %v, want %v", err, errDisk)
+	}
+	if err := <-done; err == nil {
+		t.Fatal("waiter was woken by a result that was not journaled")
+	}
+	if _, ok := box.Get("job"); ok {
+		t.Fatal("result visible after journal error")
+	}
+	if stats := box.Stats(); stats.Items != 0 {
+		t.Fatalf("items = %d, want 0", stats.Items)
+	}
+}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"sync/atomic"
+)
+
+// Stats 缓存统计信息.
+type Stats struct {
+	Items        int   // 数据项数.
+	Bytes        int64 // 内存中的数据字节数, 写入文件的数据只计算key.
+	Kept         int   // 不过期的数据项数, 不计入容量限制.
+	KeptBytes    int64 // 不过期的数据字节数, 不计入容量限制.
+	MaxItems     int   // 最大数据项数, 0不限制.
+	MaxBytes     int64 // 最大字节数, 0不限制.
+	Evictions    int64 // 淘汰的数据项数.
+	EvictedBytes int64 // 淘汰的字节数.
+}
+
+// SetLimit 设置缓存容量限制, 超过限制时淘汰最久未使用的数据, 0不限制.
+// 正在被等待的数据不会被淘汰, 不过期的数据不计入容量限制, 也不会被淘汰.
+func (box *Block) SetLimit(maxItems int, maxBytes int64) {
+	box.Lock()
+	box.conf.maxItems = maxItems
+	box.conf.maxBytes = maxBytes
+	conf := box.conf
+	box.Unlock()
+
+	box.evict(conf)
+}
+
+// Stats 获取缓存统计信息.
+func (box *Block) Stats() Stats {
+	conf := box.config()
+
+	return Stats{
+		Items:        int(atomic.LoadInt64(&box.items)),
+		Bytes:        atomic.LoadInt64(&box.bytes),
+		Kept:         int(atomic.LoadInt64(&box.keptItems)),
+		KeptBytes:    atomic.LoadInt64(&box.keptBytes),
+		MaxItems:     conf.maxItems,
+		MaxBytes:     conf.maxBytes,
+		Evictions:    atomic.LoadInt64(&box.evictions),
+		EvictedBytes: atomic.LoadInt64(&box.evictedBytes),
+	}
+}
+
+// add 添加一个数据项, 替换已经存在的数据项, 不过期的数据不加入淘汰列表, 调用方需要持有分片锁.
+func (box *Block) add(conf config, s *shard, key string, itm *Item) {
+	old, ok := s.items[key]
+	box.lruMu.Lock()
+	if ok && old.elem != nil {
+		box.lru.Remove(old.elem)
+	}
+	if !itm.kept() {
+		itm.elem = box.lru.PushFront(key)
+	}
+	box.lruMu.Unlock()
+	if ok {
+		box.count(old, -1)
+		box.release(conf, old.ref)
+	}
+	box.count(itm, 1)
+	s.items[key] = itm
+}
+
+// del 删除一个数据项与数据, 调用方需要持有分片锁.
+func (box *Block) del(conf config, s *shard, key string, itm *Item) {
+	delete(s.items, key)
+	if itm.elem != nil {
+		box.lruMu.Lock()
+		box.lru.Remove(itm.elem)
+		box.lruMu.Unlock()
+	}
+	box.count(itm, -1)
+	box.remove(conf, key, itm)
+}
+
+// count 修改数据项数与字节数统计, n为1时增加, -1时减少.
+func (box *Block) count(itm *Item, n int64) {
+	atomic.AddInt64(&box.items, n)
+	atomic.AddInt64(&box.bytes, n*itm.size)
+	if itm.kept() {
+		atomic.AddInt64(&box.keptItems, n)
+		atomic.AddInt64(&box.keptBytes, n*itm.size)
+	}
+}
+
+// resize 修改数据项大小, 调用方需要持有分片锁.
+func (box *Block) resize(itm *Item, size int64) {
+	atomic.AddInt64(&box.bytes, size-itm.size)
+	if itm.kept() {
+		atomic.AddInt64(&box.keptBytes, size-itm.size)
+	}
+	itm.size = size
+}
+
+// over 判定是否超过容量限制, 不过期的数据不计入容量限制.
+func (box *Block) over(conf config) bool {
+	items := atomic.LoadInt64(&box.items) - atomic.LoadInt64(&box.keptItems)
+	bytes := atomic.LoadInt64(&box.bytes) - atomic.LoadInt64(&box.keptBytes)
+
+	return (conf.maxItems > 0 && items > int64(conf.maxItems)) ||
+		(conf.maxBytes > 0 && bytes > conf.maxBytes)
+}
+
+// touch 标记数据最近被使用, 移到淘汰列表头部, 调用方需要持有分片锁, 读锁即可.
+func (box *Block) touch(itm *Item) {
+	if itm.elem == nil {
+		return
+	}
+	box.lruMu.Lock()
+	box.lru.MoveToFront(itm.elem)
+	box.lruMu.Unlock()
+}
+
+// evict 从最久未使用的数据开始淘汰, 直到不超过容量限制, 调用方不能持有分片锁.
+// 每次从淘汰列表尾部取出一个key, 再锁定所在的分片淘汰, 有订阅者等待的数据移到头部.
+func (box *Block) evict(conf config) {
+	box.lruMu.Lock()
+	n := box.lru.Len()
+	box.lruMu.Unlock()
+
+	// 每个数据最多检查一次.
+	for ; n > 0 && box.over(conf); n-- {
+		box.lruMu.Lock()
+		e := box.lru.Back()
+		box.lruMu.Unlock()
+		if e == nil {
+			return
+		}
+		key := e.Value.(string)
+		s := box.shard(key)
+		s.Lock()
+		// 取出后数据可能已经被删除或者替换.
+		if itm, ok := s.items[key]; ok && itm.elem == e {
+			if _, ok := s.channels[key]; ok {
+				// 有订阅者等待的数据不淘汰.
+				box.touch(itm)
+			} else {
+				atomic.AddInt64(&box.evictions, 1)
+				atomic.AddInt64(&box.evictedBytes, itm.size)
+				box.del(conf, s, key, itm)
+				// 日志写入失败时重启后数据恢复, 再次超过容量限制时淘汰.
+				box.recordDelete(conf, key)
+			}
+		}
+		s.Unlock()
+	}
+}
+
+// itemSize 数据项占用内存的字节数.
+func itemSize(key string, value []byte, ref string) int64 {
+	if ref != "" {
+
+		return int64(len(key))
+	}
+
+	return int64(len(key) + len(value))
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testKey 测试使用的数据key.
+func testKey(i int) string {
+
+	return "job-" + strconv.Itoa(i)
+}
+
+// TestEvictOldest 超过容量限制时淘汰最早写入的数据, 与数据所在的分片无关.
+func TestEvictOldest(t *testing.T) {
+	box := NewCache(nil)
+	box.SetLimit(5, 0)
+	for i := 1; i <= 12; i++ {
+		box.Set(testKey(i), []byte("result"), time.Minute, false)
+	}
+
+	for i := 1; i <= 12; i++ {
+		_, ok := box.Get(testKey(i))
+		if want := i > 7; ok != want {
+			t.Errorf("%s exists = %v, want %v", testKey(i), ok, want)
+		}
+	}
+	if stats := box.Stats(); stats.Items != 5 || stats.Evictions != 7 {
+		t.Fatalf("items = %d, evictions = %d, want 5, 7", stats.Items, stats.Evictions)
+	}
+}
+
+// TestEvictLeastRecentlyUsed 最近读取的数据最后淘汰.
+func TestEvictLeastRecentlyUsed(t *testing.T) {
+	box := NewCache(nil)
+	box.SetLimit(3, 0)
+	for i := 1; i <= 3; i++ {
+		box.Set(testKey(i), []byte("result"), time.Minute, false)
+	}
+	box.Get(testKey(1))
+	box.Set(testKey(4), []byte("result"), time.Minute, false)
+
+	for i, want := range map[int]bool{1: true, 2: false, 3: true, 4: true} {
+		if _, ok := box.Get(testKey(i)); ok != want {
+			t.Errorf("%s exists = %v, want %v", testKey(i), ok, want)
+		}
+	}
+}
+
+// TestEvictSkipsWaited 正在被等待的数据不淘汰.
+func TestEvictSkipsWaited(t *testing.T) {
+	box := NewCache(nil)
+	box.SetLimit(2, 0)
+	box.Set(testKey(1), []byte("result"), time.Minute, false)
+	done := make(chan error)
+	go func() {
+		done <- box.WaitAll([]string{testKey(1), testKey(9)}, time.Second, nil)
+	}()
+	// 等待订阅完成.
+	time.Sleep(20 * time.Millisecond)
+	for i := 2; i <= 4; i++ {
+		box.Set(testKey(i), []byte("result"), time.Minute, false)
+	}
+	if _, ok := box.Get(testKey(1)); !ok {
+		t.Fatal("waited result was evicted")
+	}
+	box.Set(testKey(9), []byte("result"), time.Minute, false)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestEvictSkipsKept 不过期的数据不计入容量限制, 也不会被淘汰.
+func TestEvictSkipsKept(t *testing.T) {
+	box := NewCache(nil)
+	box.SetLimit(3, 0)
+	box.Set(testKey(0), []byte("result"), 0, false)
+	for i := 1; i <= 10; i++ {
+		box.Set(testKey(i), []byte("result"), time.Minute, false)
+	}
+
+	if _, ok := box.Get(testKey(0)); !ok {
+		t.Fatal("kept result was evicted")
+	}
+	stats := box.Stats()
+	if stats.Items != 4 || stats.Kept != 1 || stats.Evictions != 7 {
+		t.Fatalf("items = %d, kept = %d, evictions = %d, want 4, 1, 7", stats.Items, stats.Kept, stats.Evictions)
+	}
+	box.Delete(testKey(0))
+	if stats := box.Stats(); stats.Kept != 0 || stats.KeptBytes != 0 {
+		t.Fatalf("kept = %d, kept bytes = %d after delete, want 0, 0", stats.Kept, stats.KeptBytes)
+	}
+}
@@ -90,14 +90,18 @@ class TaskClient
     /**
      * 添加任务到队列.
      *
-     * @param string $tube 队列名称.
-     * @param string $data 数据.
+     * @param string  $tube   队列名称.
+     * @param string  $data   数据.
+     * @param integer $ttr    任务执行时间限制(秒), 0使用队列配置.
+     * @param mixed   $delay  延迟执行秒数, 或者"@"开头的unix时间戳.
+     * @param integer $pri    优先级, 数字越小越优先.
+     * @param string  $result 结果保留策略: ttl保留到有效期结束, once第一次读取后删除, keep保留到被删除.
      *
      * @return boolean
      */
-    public function AddJob($tube, $data)
+    public function AddJob($tube, $data, $ttr = 0, $delay = 0, $pri = 1024, $result = "ttl")
     {
-        $str = $this->format(array("AddJob", $tube, $data));
+        $str = $this->format(array("AddJob", $tube, $data, $ttr, $delay, $pri, $result));
         $ok = $this->finish($str);
         if ($ok !== false) {
 
@@ -107,6 +111,85 @@ class TaskClient
         return false;
     }
 
+    /**
+     * 批量添加任务到队列, 一次发送全部请求后按顺序读取结果, 连接会切换为按请求顺序回复.
+     *
+     * @param array $jobs 任务列表, 每个任务为 array($tube, $data, $ttr, $delay, $pri, $result), 后面的参数可以省略.
+     *
+     * @return array|boolean 按顺序返回每个任务的唯一标示KEY, 添加失败的任务为false.
+     */
+    public function AddJobs($jobs)
+    {
+        $str = $this->format(array("Pipeline", 1));
+        foreach ($jobs as $job) {
+            $job = array_values($job) + array("", "", 0, 0, 1024, "ttl");
+            $str .= $this->format(array_merge(array("AddJob"), $job));
+        }
+        $list = $this->batch($str, count($jobs) + 1);
+        if ($list === false || $list[0][0] != 1) {
+
+            return false;
+        }
+
+        $keys = array();
+        for ($i = 1; $i < count($list); $i++) {
+            if ($list[$i][0] == 1) {
+                $keys[] = $list[$i][2];
+            } else {
+                self::$errno = $list[$i][0];
+                self::$errmsg = $list[$i][1];
+                $keys[] = false;
+            }
+        }
+
+        return $keys;
+    }
+
+    /**
+     * 协议协商, 获取服务支持的协议版本, 命令与功能.
+     *
+     * @param integer $proto 之后使用的协议版本, 0不修改, TaskClient只支持协议版本1.
+     *
+     * @return array|false 如: array("version" => "1.0.0", "proto" => "1", "commands" => array("AddJob", ...), "features" => array("pipeline", ...)).
+     */
+    public function Hello($proto = 0)
+    {
+        $arr = array("Hello");
+        if (!empty($proto)) {
+            $arr[] = $proto;
+        }
+        $str = $this->format($arr);
+        $ok = $this->finish($str);
+        if ($ok !== false) {
+            $info = $this->pairs($ok);
+            foreach (array("protos", "commands", "features") as $name) {
+                $info[$name] = isset($info[$name]) && $info[$name] !== "" ? explode(",", $info[$name]) : array();
+            }
+
+            return $info;
+        }
+
+        return false;
+    }
+
+    /**
+     * 设置连接是否按请求顺序回复, 按顺序回复时可以一次发送多个请求.
+     *
+     * @param boolean $ordered 是否按请求顺序回复.
+     *
+     * @return boolean
+     */
+    public function Pipeline($ordered = true)
+    {
+        $str = $this->format(array("Pipeline", $ordered ? 1 : 0));
+        $ok = $this->finish($str);
+        if ($ok !== false) {
+            return true;
+        }
+
+        return false;
+    }
+
     /**
      * 获取一个任务.
      *
@@ -130,16 +213,71 @@ class TaskClient
         return false;
     }
 
+    /**
+     * 等待并获取一个任务, 队列中没有任务时等待, 超时错误号为408.
+     *
+     * @param string $tube    队列名称.
+     * @param string &$_key   任务唯一标示KEY.
+     * @param string &$_tmp   数据.
+     * @param mixed  $timeout 等待超时, 没有单位为毫秒, 也可以为"3000ms"或"3s".
+     *
+     * @return bool
+     */
+    public function ReserveWait($tube, &$_key, &$_tmp, $timeout = 60000)
+    {
+        $str = $this->format(array("ReserveWait", $tube, $timeout));
+        $ok = $this->finish($str);
+        if ($ok !== false) {
+            $_key = $ok[0];
+            $_tmp = $ok[1];
+
+            return true;
+        }
+
+        return false;
+    }
+
+
+    /**
+     * 从多个队列中等待并获取一个任务, 超时错误号为408.
+     *
+     * @param array  $tubes   队列名称与权重, 如: array("stock" => 3, "order" => 1), 为列表时按先后顺序获取.
+     * @param string &$_key   任务唯一标示KEY.
+     * @param string &$_tmp   数据.
+     * @param string &$_tube  任务所在的队列.
+     * @param mixed  $timeout 等待超时, 没有单位为毫秒, 也可以为"3000ms"或"3s".
+     *
+     * @return bool
+     */
+    public function ReserveAny($tubes, &$_key, &$_tmp, &$_tube, $timeout = 60000)
+    {
+        $args = array("ReserveAny");
+        foreach ($tubes as $tube => $weight) {
+            $args[] = is_int($tube) ? $weight : $tube . ":" . $weight;
+        }
+        $args[] = $timeout;
+        $str = $this->format($args);
+        $ok = $this->finish($str);
+        if ($ok !== false) {
+            $_key = $ok[0];
+            $_tmp = $ok[1];
+            $_tube = $ok[2];
+
+            return true;
+        }
+
+        return false;
+    }
 
     /**
      * 获取任务完成的结果.
      *
      * @param string $key     唯一标示KEY.
-     * @param string $timeout 超时.
+     * @param mixed  $timeout 等待超时, 没有单位为毫秒, 也可以为"3000ms"或"3s".
      *
      * @return boolean
      */
-    public function GetReturn($key, $timeout)
+    public function GetReturn($key, $timeout = 60000)
     {
         $str = $this->format(array("GetReturn", $key, $timeout));
         $ok = $this->finish($str);
@@ -151,17 +289,136 @@ class TaskClient
         return false;
     }
 
+    /**
+     * 获取多个任务中第一个完成的结果.
+     *
+     * @param array $keys    唯一标示KEY列表.
+     * @param mixed $timeout 等待超时, 没有单位为毫秒, 也可以为"3000ms"或"3s".
+     *
+     * @return array|false 第一个完成任务的KEY与结果, 如: array("key" => $key, "data" => $data).
+     */
+    public function GetReturnAny($keys, $timeout = 60000)
+    {
+        $str = $this->format(array_merge(array("GetReturnAny"), $keys, array($timeout)));
+        $ok = $this->finish($str);
+        if ($ok !== false) {
+
+            return array("key" => $ok[0], "data" => $ok[1]);
+        }
+
+        return false;
+    }
+
+    /**
+     * 获取多个任务全部完成后的结果.
+     *
+     * @param array $keys    唯一标示KEY列表.
+     * @param mixed $timeout 等待超时, 没有单位为毫秒, 也可以为"3000ms"或"3s".
+     *
+     * @return array|false 每个任务的状态与结果, 如: array($key => array("status" => 1, "data" => $data)), 状态410为任务失败.
+     */
+    public function GetReturnAll($keys, $timeout = 60000)
+    {
+        $str = $this->format(array_merge(array("GetReturnAll"), $keys, array($timeout)));
+        $ok = $this->finish($str);
+        if ($ok !== false) {
+            $tmp = array();
+            $len = count($ok);
+            for ($i = 0; $i + 2 < $len; $i += 3) {
+                $tmp[$ok[$i]] = array("status" => intval($ok[$i + 1]), "data" => $ok[$i + 2]);
+            }
+
+            return $tmp;
+        }
+
+        return false;
+    }
+
     /**
      * 设置任务的数据.
      *
-     * @param string $key  任务唯一标示KEY.
-     * @param string $data 数据.
+     * @param string  $key  任务唯一标示KEY.
+     * @param string  $data 数据.
+     * @param integer $ttl  结果保留秒数, 默认600秒.
+     *
+     * @return boolean
+     */
+    public function SetReturn($key, $data, $ttl = 600)
+    {
+        $str = $this->format(array("SetReturn", $key, $data, $ttl));
+        $ok = $this->finish($str);
+        if ($ok !== false) {
+            return true;
+        }
+
+        return false;
+    }
+
+    /**
+     * 删除任务的结果.
+     *
+     * @param string $key 任务唯一标示KEY.
+     *
+     * @return boolean
+     */
+    public function DelReturn($key)
+    {
+        $str = $this->format(array("DelReturn", $key));
+        $ok = $this->finish($str);
+        if ($ok !== false) {
+            return true;
+        }
+
+        return false;
+    }
+
+    /**
+     * 延长正在执行任务的时间.
+     *
+     * @param string $key 任务唯一标示KEY.
+     *
+     * @return boolean
+     */
+    public function Touch($key)
+    {
+        $str = $this->format(array("Touch", $key));
+        $ok = $this->finish($str);
+        if ($ok !== false) {
+            return true;
+        }
+
+        return false;
+    }
+
+    /**
+     * 管理员认证.
+     *
+     * @param string $password 管理员密码.
+     *
+     * @return boolean
+     */
+    public function Auth($password)
+    {
+        $str = $this->format(array("Auth", $password));
+        $ok = $this->finish($str);
+        if ($ok !== false) {
+            return true;
+        }
+
+        return false;
+    }
+
+    /**
+     * 将正在执行的任务放回队列.
+     *
+     * @param string  $key   任务唯一标示KEY.
+     * @param integer $delay 延迟放回秒数.
      *
      * @return boolean
      */
-    public function SetReturn($key, $data)
+    public function Release($key, $delay = 0)
     {
-        $str = $this->format(array("SetReturn", $key, $data));
+        $str = $this->format(array("Release", $key, $delay));
         $ok = $this->finish($str);
         if ($ok !== false) {
             return true;
@@ -170,6 +427,203 @@ class TaskClient
         return false;
     }
 
+    /**
+     * 任务执行失败.
+     *
+     * @param string $key    任务唯一标示KEY.
+     * @param string $reason 失败原因.
+     *
+     * @return boolean
+     */
+    public function Fail($key, $reason)
+    {
+        $str = $this->format(array("Fail", $key, $reason));
+        $ok = $this->finish($str);
+        if ($ok !== false) {
+            return true;
+        }
+
+        return false;
+    }
+
+    /**
+     * 埋葬正在执行的任务, 等待人工处理.
+     *
+     * @param string $key    任务唯一标示KEY.
+     * @param string $reason 原因.
+     *
+     * @return boolean
+     */
+    public function Bury($key, $reason = "")
+    {
+        $str = $this->format(array("Bury", $key, $reason));
+        $ok = $this->finish($str);
+        if ($ok !== false) {
+            return true;
+        }
+
+        return false;
+    }
+
+    /**
+     * 将队列中埋葬的任务放回队列.
+     *
+     * @param string  $tube 队列名称.
+     * @param integer $n    最多放回的任务数.
+     *
+     * @return integer|false 放回的任务数.
+     */
+    public function Kick($tube, $n)
+    {
+        $str = $this->format(array("Kick", $tube, $n));
+        $ok = $this->finish($str);
+        if ($ok !== false) {
+            return intval($ok[0]);
+        }
+
+        return false;
+    }
+
+    /**
+     * 设置队列配置.
+     *
+     * @param string  $tube  队列名称.
+     * @param string  $name  配置名称, ttr: 任务执行时间限制(秒), attempts: 任务最大执行次数.
+     * @param integer $value 配置值.
+     *
+     * @return boolean
+     */
+    public function TubeSet($tube, $name, $value)
+    {
+        $str = $this->format(array("TubeSet", $tube, $name, $value));
+        $ok = $this->finish($str);
+        if ($ok !== false) {
+            return true;
+        }
+
+        return false;
+    }
+
+    /**
+     * 获取队列统计信息.
+     *
+     * @param string $tube 队列名称.
+     *
+     * @return array|false 等待执行任务数与各优先级任务数, 如: array("ready" => 3, "pri.1024" => 3).
+     */
+    public function StatsTube($tube)
+    {
+        $str = $this->format(array("StatsTube", $tube));
+        $ok = $this->finish($str);
+        if ($ok !== false) {
+
+            return array_map("intval", $this->pairs($ok));
+        }
+
+        return false;
+    }
+
+    /**
+     * 获取结果缓存统计信息.
+     *
+     * @return array|false 结果数与淘汰数, 如: array("items" => 3, "bytes" => 1024, "evictions" => 0).
+     */
+    public function StatsCache()
+    {
+        $str = $this->format(array("StatsCache"));
+        $ok = $this->finish($str);
+        if ($ok !== false) {
+
+            return array_map("intval", $this->pairs($ok));
+        }
+
+        return false;
+    }
+
+    /**
+     * 查看队列中第一个等待执行的任务.
+     *
+     * @param string $tube 队列名称.
+     *
+     * @return array|false 任务信息.
+     */
+    public function PeekReady($tube)
+    {
+        return $this->info(array("PeekReady", $tube));
+    }
+
+    /**
+     * 查看队列中正在执行的任务.
+     *
+     * @param string $tube 队列名称.
+     *
+     * @return array|false 任务信息.
+     */
+    public function PeekReserved($tube)
+    {
+        return $this->info(array("PeekReserved", $tube));
+    }
+
+    /**
+     * 查看队列中第一个埋葬的任务.
+     *
+     * @param string $tube 队列名称.
+     *
+     * @return array|false 任务信息.
+     */
+    public function PeekBuried($tube)
+    {
+        return $this->info(array("PeekBuried", $tube));
+    }
+
+    /**
+     * 查看任务信息.
+     *
+     * @param string $key 任务唯一标示KEY.
+     *
+     * @return array|false 任务信息, 包含key,data,state,tube,age,attempts,reserved-by,reason.
+     */
+    public function JobInfo($key)
+    {
+        return $this->info(array("JobInfo", $key));
+    }
+
+    /**
+     * 获取任务信息.
+     *
+     * @param array $arr 命令数据.
+     *
+     * @return array|false
+     */
+    private function info($arr)
+    {
+        $ok = $this->finish($this->format($arr));
+        if ($ok !== false) {
+
+            return $this->pairs($ok);
+        }
+
+        return false;
+    }
+
+    /**
+     * 将键值对列表转换为数组.
+     *
+     * @param array $arr 键值对列表.
+     *
+     * @return array
+     */
+    private function pairs($arr)
+    {
+        $tmp = array();
+        $len = count($arr);
+        for ($i = 0; $i + 1 < $len; $i += 2) {
+            $tmp[$arr[$i]] = $arr[$i + 1];
+        }
+
+        return $tmp;
+    }
+
     /**
      * 添加队列通知, 如果队列中存在数据或者向队列添加数据,立即返回.
      *
@@ -236,14 +690,53 @@ class TaskClient
         return false;
     }
 
+    /**
+     * 一次发送多个请求, 按顺序读取全部结果.
+     *
+     * @param string  $str 格式化后的请求数据.
+     * @param integer $n   请求数量.
+     *
+     * @return array|boolean
+     */
+    private function batch($str, $n)
+    {
+        if (!self::$status) {
+            $this->connect();
+        }
+        if (!self::$status) {
+            return false;
+        }
+
+        $list = array();
+        try {
+            $count = 0;
+            $len = strlen($str);
+            while ($count < $len) {
+                $w = @socket_write(self::$socket, substr($str, $count));
+                $count += $w;
+                $this->doExt();
+            }
+
+            $buf = "";
+            for ($i = 0; $i < $n; $i++) {
+                $list[] = $this->getOneRequest($buf);
+            }
+        } catch (Exception $e) {
+            return false;
+        }
+
+        return $list;
+    }
+
     /**
      * 获取一个完整的请求结果.
      *
+     * @param string &$buf 读取缓存, 连续读取多个结果时共用.
+     *
      * @return boolean
      */
-    private function getOneRequest()
+    private function getOneRequest(&$buf = "")
     {
-        $buf = "";
         $data = array();
         $this->readString($buf, "*");
         $len = $this->readString($buf, "\n");
@@ -263,7 +756,7 @@ class TaskClient
         while (true) {
             $attr =strpos($buf, $delim);
             if ($attr === false) {
-                $buf = socket_read(self::$socket, 2048, PHP_BINARY_READ);
+                $buf .= socket_read(self::$socket, 2048, PHP_BINARY_READ);
                 $this->doExt();
                 continue;
             }
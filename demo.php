@@ -21,6 +21,27 @@ function test1() {
     }
 }
 
+// Client, 添加多个任务后同时等待结果.
+function test3() {
+    $task = new TaskClient();
+    $task->connect("127.0.0.1", "9090");
+    $keys = array();
+    for ($i = 0; $i < 10; $i++) {
+        if ($key = $task->AddJob("test1", "data" . $i)) {
+            $keys[] = $key;
+        }
+    }
+
+    // 所有任务完成或超时后返回, 不会因为一个任务慢阻塞其他任务的结果.
+    if (($results = $task->GetReturnAll($keys, "10s")) !== false) {
+        foreach ($results as $key => $result) {
+            var_dump($key, $result["status"], $result["data"]);
+        }
+    } else {
+        var_dump($task->GetErrMsg());
+    }
+}
+
 // Worker.
 function test2() {
     $task = new TaskClient();
@@ -31,4 +52,18 @@ function test2() {
             $task->SetReturn($key, "data(设置任务完成后结果数据)");
         }
     }
+}
+
+// Worker, 等待并获取任务, 没有Usr1与GetJob之间的竞争.
+function test4() {
+    $task = new TaskClient();
+    $task->connect("127.0.0.1", "9090");
+    while (true) {
+        if ($task->ReserveWait("test1", $key, $data, "30s")) {
+            // TODO 处理数据.
+            $task->SetReturn($key, "data(设置任务完成后结果数据)");
+        } else if ($task->GetErrNo() != 408) {
+            break;
+        }
+    }
 }
\ No newline at end of file
@@ -0,0 +1,394 @@
+package journal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 日志同步到磁盘的策略.
+const (
+	// SyncAlways 每次写入都同步到磁盘.
+	SyncAlways = "always"
+	// SyncEverySec 每秒同步一次到磁盘.
+	SyncEverySec = "everysec"
+	// SyncNo 不主动同步, 由操作系统决定.
+	SyncNo = "no"
+)
+
+// ErrFormat 日志数据格式错误.
+var ErrFormat = errors.New("journal format error")
+
+// Journal 追加写日志接口.
+type Journal interface {
+	// Append 追加一条记录.
+	Append(args ...[]byte) error
+	// Replay 从头读取快照与日志中的所有记录.
+	Replay(f func(rec [][]byte) error) error
+	// Snapshot 生成快照并压缩日志, dump将当前数据写入快照.
+	Snapshot(dump func(w Journal) error) error
+	// Sync 同步数据到磁盘.
+	Sync() error
+	// Close 关闭日志.
+	Close() error
+}
+
+// file 文件日志结构体.
+type file struct {
+	sync.Mutex                  // 锁.
+	snap       sync.Mutex       // 快照锁, 同一时间只能生成一个快照.
+	name       string           // 文件名.
+	fd         *os.File         // 文件对象.
+	buf        *bufio.Writer    // 写缓存.
+	policy     string           // 同步策略.
+	dirty      bool             // 存在未同步的数据.
+	lazy       bool             // 写入时不刷新缓存, 用于生成快照.
+	stop       chan interface{} // 关闭通知.
+	log        *log.Logger      // 日志记录对象.
+}
+
+// SnapshotSuffix 快照文件名后缀.
+const SnapshotSuffix = ".snapshot"
+
+// Open 打开一个日志文件, 不存在则创建.
+func Open(name, policy string, l *log.Logger) (Journal, error) {
+	switch policy {
+	case SyncAlways, SyncEverySec, SyncNo:
+	default:
+		return nil, fmt.Errorf("journal: unknown fsync policy %q", policy)
+	}
+
+	name, err := filepath.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+	fd, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &file{
+		name:   name,
+		fd:     fd,
+		buf:    bufio.NewWriter(fd),
+		policy: policy,
+		stop:   make(chan interface{}),
+		log:    l,
+	}
+	if policy == SyncEverySec {
+		go j.syncEverySec()
+	}
+
+	return j, nil
+}
+
+// Append 追加一条记录, 格式与网络协议相同: *N\n$len\ndata\n.
+func (j *file) Append(args ...[]byte) error {
+	j.Lock()
+	defer j.Unlock()
+
+	fmt.Fprintf(j.buf, "*%d\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(j.buf, "$%d\n", len(arg))
+		j.buf.Write(arg)
+		j.buf.WriteByte('\n')
+	}
+	if j.lazy {
+		j.dirty = true
+
+		return nil
+	}
+	err := j.buf.Flush()
+	if err == nil {
+		j.dirty = true
+		if j.policy == SyncAlways {
+			err = j.sync()
+		}
+	}
+	if err != nil {
+		j.logf("journal: append error: %v", err)
+	}
+
+	return err
+}
+
+// Replay 先读取快照, 再从头读取日志中的所有记录, 日志尾部不完整的记录会被截断.
+// 快照之后的日志记录可能已经包含在快照中, 记录必须可以重复执行.
+func (j *file) Replay(f func(rec [][]byte) error) error {
+	j.Lock()
+	defer j.Unlock()
+
+	snap, err := os.Open(j.name + SnapshotSuffix)
+	if err == nil {
+		_, err = j.replay(snap, f)
+		snap.Close()
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if _, err := j.fd.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	offset, err := j.replay(j.fd, f)
+	if err != nil {
+		return err
+	}
+
+	if err := j.fd.Truncate(offset); err != nil {
+		return err
+	}
+	_, err = j.fd.Seek(offset, io.SeekStart)
+
+	return err
+}
+
+// Snapshot 生成快照并压缩日志.
+// 先记录日志当前位置, 再生成快照, 最后只保留该位置之后的日志记录, 生成快照期间不阻塞写入.
+func (j *file) Snapshot(dump func(w Journal) error) error {
+	j.snap.Lock()
+	defer j.snap.Unlock()
+
+	j.Lock()
+	if err := j.buf.Flush(); err != nil {
+		j.Unlock()
+		return err
+	}
+	offset, err := j.fd.Seek(0, io.SeekEnd)
+	j.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// 生成快照.
+	name := j.name + SnapshotSuffix
+	fd, err := os.OpenFile(name+".tmp", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := &file{
+		name:   name,
+		fd:     fd,
+		buf:    bufio.NewWriter(fd),
+		policy: SyncNo,
+		lazy:   true,
+		log:    j.log,
+	}
+	err = dump(w)
+	if err == nil {
+		err = w.Close()
+	} else {
+		fd.Close()
+	}
+	if err == nil {
+		err = os.Rename(name+".tmp", name)
+	}
+	if err != nil {
+		os.Remove(name + ".tmp")
+		return err
+	}
+
+	return j.compact(offset)
+}
+
+// compact 压缩日志, 只保留offset之后的记录.
+func (j *file) compact(offset int64) error {
+	j.Lock()
+	defer j.Unlock()
+
+	if err := j.buf.Flush(); err != nil {
+		return err
+	}
+	end, err := j.fd.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	fd, err := os.OpenFile(j.name+".tmp", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fd, io.NewSectionReader(j.fd, offset, end-offset))
+	if err == nil {
+		err = fd.Sync()
+	}
+	fd.Close()
+	if err == nil {
+		err = os.Rename(j.name+".tmp", j.name)
+	}
+	if err != nil {
+		os.Remove(j.name + ".tmp")
+		return err
+	}
+
+	fd, err = os.OpenFile(j.name, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	j.fd.Close()
+	j.fd = fd
+	j.buf = bufio.NewWriter(fd)
+	j.dirty = false
+
+	return nil
+}
+
+// Sync 同步数据到磁盘.
+func (j *file) Sync() error {
+	j.Lock()
+	defer j.Unlock()
+
+	return j.sync()
+}
+
+// Close 关闭日志.
+func (j *file) Close() error {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.policy == SyncEverySec && j.stop != nil {
+		close(j.stop)
+	}
+	if err := j.sync(); err != nil {
+		return err
+	}
+
+	return j.fd.Close()
+}
+
+// sync 同步数据到磁盘, 调用方需要持有锁.
+func (j *file) sync() error {
+	if !j.dirty {
+		return nil
+	}
+	if err := j.buf.Flush(); err != nil {
+		return err
+	}
+	j.dirty = false
+
+	return j.fd.Sync()
+}
+
+// syncEverySec 每秒同步一次数据.
+func (j *file) syncEverySec() {
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			if err := j.Sync(); err != nil {
+				j.logf("journal: sync error: %v", err)
+			}
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+// logf 记录错误日志.
+func (j *file) logf(format string, args ...interface{}) {
+	if j.log != nil {
+		j.log.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// replay 读取所有完整的记录, 返回完整记录的字节数.
+func (j *file) replay(fd io.Reader, f func(rec [][]byte) error) (int64, error) {
+	r := bufio.NewReader(fd)
+	var offset int64
+	for {
+		rec, n, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// 写入时崩溃, 丢弃不完整的记录.
+			j.logf("journal: drop incomplete record at %d: %v", offset, err)
+			break
+		}
+		if err = f(rec); err != nil {
+			return offset, err
+		}
+		offset += n
+	}
+
+	return offset, nil
+}
+
+// readRecord 读取一条记录, 返回记录与读取的字节数.
+func readRecord(r *bufio.Reader) ([][]byte, int64, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	n := int64(len(line))
+	count, err := parseHead(line, '*')
+	if err != nil {
+		return nil, n, err
+	}
+
+	rec := make([][]byte, count)
+	for k := range rec {
+		line, err = r.ReadSlice('\n')
+		if err != nil {
+			return nil, n, noEOF(err)
+		}
+		n += int64(len(line))
+		size, err := parseHead(line, '$')
+		if err != nil {
+			return nil, n, err
+		}
+
+		b := make([]byte, size+1)
+		if _, err = io.ReadFull(r, b); err != nil {
+			return nil, n, noEOF(err)
+		}
+		n += int64(len(b))
+		if b[size] != '\n' {
+			return nil, n, ErrFormat
+		}
+		rec[k] = b[:size]
+	}
+
+	return rec, n, nil
+}
+
+// parseHead 解析记录头, 如: *3\n $10\n.
+func parseHead(line []byte, prefix byte) (int, error) {
+	if len(line) < 3 || line[0] != prefix {
+		return 0, ErrFormat
+	}
+	n, err := strconv.Atoi(string(line[1 : len(line)-1]))
+	if err != nil || n < 0 {
+		return 0, ErrFormat
+	}
+
+	return n, nil
+}
+
+// noEOF 记录中途结束视为不完整.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
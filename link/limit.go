@@ -0,0 +1,70 @@
+package link
+
+import (
+	"bufio"
+)
+
+// Limits 请求大小限制, 0不限制.
+type Limits struct {
+	MaxArgs    int // 一个请求最多参数个数, 包括命令名称.
+	MaxArgSize int // 一个参数最大字节数.
+	MaxRequest int // 一个请求所有参数最大字节数.
+}
+
+// DefaultLimits 默认请求大小限制, 启动服务前修改.
+var DefaultLimits = Limits{
+	MaxArgs:    1024,
+	MaxArgSize: 8 * 1024 * 1024,
+	MaxRequest: 16 * 1024 * 1024,
+}
+
+// ProtocolError 请求格式错误或者超过大小限制, 回复错误后关闭连接.
+type ProtocolError string
+
+// Error 错误信息.
+func (e ProtocolError) Error() string {
+
+	return string(e)
+}
+
+// checkArgs 检查参数个数.
+func (l *Limits) checkArgs(n int) error {
+	if n < 0 {
+
+		return ProtocolError("invalid multibulk length")
+	}
+	if l.MaxArgs > 0 && n > l.MaxArgs {
+
+		return ProtocolError("too many arguments")
+	}
+
+	return nil
+}
+
+// checkSize 检查参数大小, total为已经读取的参数大小.
+func (l *Limits) checkSize(size, total int) error {
+	if size < 0 {
+
+		return ProtocolError("invalid bulk length")
+	}
+	if l.MaxArgSize > 0 && size > l.MaxArgSize {
+
+		return ProtocolError("argument too large")
+	}
+	if l.MaxRequest > 0 && total+size > l.MaxRequest {
+
+		return ProtocolError("request too large")
+	}
+
+	return nil
+}
+
+// readError 读取错误, 行太长为协议错误, 其他为网络错误.
+func readError(err error, msg string) error {
+	if err == bufio.ErrBufferFull {
+
+		return ProtocolError(msg)
+	}
+
+	return err
+}
@@ -2,11 +2,11 @@ package link
 
 import (
 	"bufio"
-	"errors"
 	"log"
 	"net"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 	"bytes"
 	"fmt"
@@ -19,6 +19,8 @@ type server struct {
 	CallFunc    func(interface{}) // 当客户端网络断开的时候，调用该函数.
 	ErrorLog    *log.Logger       // 日志记录对象.
 	StopMessage chan interface{}
+	Proto       int    // 连接使用的协议, ProtoLegacy或ProtoRESP2.
+	Limits      Limits // 请求大小限制.
 }
 
 // ListenAndServe 监听端口与启动服务，服务不断的建立连接与读取数据.
@@ -99,10 +101,11 @@ func (srv *server) logf(format string, args ...interface{}) {
 func (srv *server) NewConn(rw net.Conn) Connect {
 
 	conn := &connect{
-		conn: rw,
-		buf:  bufio.NewReader(rw),
-		srv:  srv,
-		C:    make(chan interface{}, 2),
+		conn:  rw,
+		buf:   bufio.NewReader(rw),
+		srv:   srv,
+		C:     make(chan interface{}, 2),
+		proto: int32(srv.Proto),
 	}
 
 	return conn
@@ -111,10 +114,11 @@ func (srv *server) NewConn(rw net.Conn) Connect {
 // NewConnect 一个连接请求.
 func NewConnect(rw net.Conn) Connect {
 	conn := &connect{
-		conn: rw,
-		buf:  bufio.NewReader(rw),
-		srv:  nil,
-		C:    make(chan interface{}, 2),
+		conn:  rw,
+		buf:   bufio.NewReader(rw),
+		srv:   nil,
+		C:     make(chan interface{}, 2),
+		proto: ProtoLegacy,
 	}
 
 	return conn
@@ -122,30 +126,114 @@ func NewConnect(rw net.Conn) Connect {
 
 // connect 连接结构体.
 type connect struct {
-	sync.RWMutex          // 读写锁.
-	conn net.Conn         // 网络连接，接口对象.
-	buf  *bufio.Reader    // buf读取缓存.
-	srv  *server          // 服务结构对象.
-	C    chan interface{} // 通知网络连接是否断开.
+	closed       int32                  // 连接已经断开, 原子操作.
+	proto        int32                  // 连接使用的协议, 原子操作.
+	sync.RWMutex                        // 读写锁.
+	wmu          sync.Mutex             // 写锁, 一次回复完整写入后才能写入下一个回复.
+	conn         net.Conn               // 网络连接，接口对象.
+	buf          *bufio.Reader          // buf读取缓存.
+	srv          *server                // 服务结构对象.
+	C            chan interface{}       // 通知网络连接是否断开.
+	values       map[string]interface{} // 连接属性.
+	ordered      bool                   // 按请求顺序处理请求并回复, 只在读取请求的协程中使用.
+	requests     chan *request          // 按顺序处理的请求.
+	done         chan struct{}          // 按顺序处理的请求全部处理完成.
 }
 
+// request 等待按顺序处理的请求.
+type request struct {
+	h    Hander   // 调用函数.
+	data [][]byte // 请求数据.
+}
+
+// RequestQueueSize 按顺序处理时, 每个连接最多读取未处理的请求数.
+const RequestQueueSize = 64
+
 // Serve 网络连接服务，不断读取数据.
 func (linker *connect) Serve() {
 	for {
 		b, err := linker.ReadOneRequest()
 		if err != nil {
 			linker.logf("tcp: connect error: %v; retrying in %v", err, linker.conn.RemoteAddr())
+			if perr, ok := err.(ProtocolError); ok {
+				// 请求格式错误或者超过大小限制, 无法继续读取下一个请求.
+				linker.WriteString("400", "协议错误: "+perr.Error())
+				err = io.EOF
+			} else if ne, ok := err.(net.Error); ok && !ne.Temporary() {
+				err = io.EOF
+			}
 			if err == io.EOF {
 				// 如果连接关闭，或者客户端关闭了连接.
+				atomic.StoreInt32(&linker.closed, 1)
 				linker.Close()
+				// 关闭通知通道, 同一个连接上所有等待中的请求都能收到, 回调之前关闭保证回调之后不再预订任务.
+				close(linker.C)
 				linker.srv.CallFunc(linker)
-				linker.C <- nil
+				if linker.requests != nil {
+					close(linker.requests)
+				}
 				break
 			}
 		}
 		if b != nil {
-			handler := DefaultServeMux.GetHandler(string(b[0]))
-			go handler.ServeDo(linker, b)
+			cmd := string(b[0])
+			if linker.Proto() == ProtoRESP2 {
+				// Redis客户端命令名称不区分大小写, 如: PING, HELLO.
+				cmd = DefaultServeMux.Canonical(cmd)
+			}
+			handler := DefaultServeMux.GetHandler(cmd)
+			if _, ok := handler.(InlineHandlerFunc); ok {
+				// 已经读取的请求处理完成后再处理, 保证回复顺序.
+				linker.stopOrdered()
+				handler.ServeDo(linker, b)
+			} else if linker.isOrdered() {
+				if linker.requests == nil {
+					linker.startOrdered()
+				}
+				linker.requests <- &request{h: handler, data: b}
+			} else {
+				go handler.ServeDo(linker, b)
+			}
+		}
+	}
+}
+
+// SetOrdered 设置是否按请求顺序处理请求并回复, 只能在读取请求的协程中调用.
+// RESP2协议的连接总是按顺序处理, 设置在切换回原有协议后生效.
+func (linker *connect) SetOrdered(ordered bool) {
+	linker.ordered = ordered
+}
+
+// isOrdered 是否按请求顺序处理, RESP2协议客户端按发送顺序读取回复, 总是按顺序处理.
+func (linker *connect) isOrdered() bool {
+
+	return linker.ordered || linker.Proto() == ProtoRESP2
+}
+
+// startOrdered 开始按顺序处理请求.
+func (linker *connect) startOrdered() {
+	linker.requests = make(chan *request, RequestQueueSize)
+	linker.done = make(chan struct{})
+	go linker.serveOrdered(linker.requests, linker.done)
+}
+
+// stopOrdered 停止按顺序处理请求, 等待已经读取的请求处理完成.
+func (linker *connect) stopOrdered() {
+	if linker.requests != nil {
+		close(linker.requests)
+		<-linker.done
+		linker.requests = nil
+	}
+}
+
+// serveOrdered 按顺序处理请求, 一个请求处理完成后才处理下一个请求.
+func (linker *connect) serveOrdered(requests chan *request, done chan struct{}) {
+	defer close(done)
+
+	for r := range requests {
+		// 连接断开后不再处理.
+		if atomic.LoadInt32(&linker.closed) == 0 {
+			r.h.ServeDo(linker, r.data)
 		}
 	}
 }
@@ -155,15 +243,43 @@ func (linker *connect) logf(format string, args ...interface{}) {
 	linker.srv.logf(format, args...)
 }
 
-// GetC 获取通信数据，如果连接关闭，获取到数据.
+// GetC 获取通信数据，如果连接关闭，通道被关闭.
 func (linker *connect) GetC() chan interface{} {
 
 	return linker.C
 }
 
+// limits 请求大小限制, 客户端连接不限制.
+func (linker *connect) limits() *Limits {
+	if linker.srv == nil {
+
+		return &Limits{}
+	}
+
+	return &linker.srv.Limits
+}
+
+// Proto 连接使用的协议.
+func (linker *connect) Proto() int {
+
+	return int(atomic.LoadInt32(&linker.proto))
+}
+
+// SetProto 设置连接使用的协议, 之后的回复按新协议格式.
+func (linker *connect) SetProto(proto int) {
+	atomic.StoreInt32(&linker.proto, int32(proto))
+}
+
 // WriteString 写入字符串数据.
 func (linker *connect) WriteString(strs ...string) (err error) {
-	b := format(strs...)
+	var b []byte
+	if linker.Proto() == ProtoRESP2 {
+		b = formatRESP(strs...)
+	} else {
+		b = format(strs...)
+	}
+	linker.wmu.Lock()
+	defer linker.wmu.Unlock()
 	defer func() {
 		if e := recover(); e != nil {
 			if err, ok := e.(error); ok {
@@ -184,6 +300,80 @@ func (linker *connect) WriteString(strs ...string) (err error) {
 	return nil
 }
 
+// WriteReader 写入字符串数据, 最后一个数据从r中读取size字节, 不需要全部读入内存.
+func (linker *connect) WriteReader(size int64, r io.Reader, strs ...string) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			if err, ok := e.(error); ok {
+				linker.logf("tcp: write error: %v; retrying in %v", err, linker.conn.RemoteAddr())
+			}
+		}
+	}()
+	linker.wmu.Lock()
+	defer linker.wmu.Unlock()
+	w := bufio.NewWriter(linker.conn)
+	eol := "\n"
+	if linker.Proto() == ProtoRESP2 {
+		writeRESPHeader(w, size, strs...)
+		eol = "\r\n"
+	} else {
+		fmt.Fprintf(w, "*%d\n", len(strs)+1)
+		for _, str := range strs {
+			fmt.Fprintf(w, "$%d\n%s\n", len(str), str)
+		}
+		fmt.Fprintf(w, "$%d\n", size)
+	}
+	if _, err = io.CopyN(w, r, size); err != nil {
+		// 数据已经部分写入, 只能关闭连接.
+		linker.logf("tcp: write error: %v; retrying in %v", err, linker.conn.RemoteAddr())
+		linker.Close()
+
+		return err
+	}
+	w.WriteString(eol)
+
+	return w.Flush()
+}
+
+// WriteInt 写入一个整数, RESP2协议为整数回复.
+func (linker *connect) WriteInt(n int64) error {
+	if linker.Proto() != ProtoRESP2 {
+
+		return linker.WriteString("1", "成功", strconv.FormatInt(n, 10))
+	}
+	linker.wmu.Lock()
+	defer linker.wmu.Unlock()
+
+	_, err := fmt.Fprintf(linker.conn, ":%d\r\n", n)
+
+	return err
+}
+
+// SetValue 设置连接的属性.
+func (linker *connect) SetValue(key string, val interface{}) {
+	linker.Lock()
+	defer linker.Unlock()
+
+	if linker.values == nil {
+		linker.values = make(map[string]interface{}, 1)
+	}
+	linker.values[key] = val
+}
+
+// GetValue 获取连接的属性.
+func (linker *connect) GetValue(key string) interface{} {
+	linker.RLock()
+	defer linker.RUnlock()
+
+	return linker.values[key]
+}
+
+// RemoteAddr 客户端地址.
+func (linker *connect) RemoteAddr() net.Addr {
+
+	return linker.conn.RemoteAddr()
+}
+
 // StopServer 停止服务.
 func (linker *connect) StopServer() {
 	linker.srv.StopServer()
@@ -199,10 +389,23 @@ func (linker *connect) ReadOneRequest() (b [][]byte, err error) {
 		}
 	}()
 
+	if linker.Proto() == ProtoRESP2 {
+		// 不是"*"开头的请求为内联命令.
+		c, err := linker.peekCommand()
+		if err != nil {
+
+			return nil, err
+		}
+		if c != '*' {
+
+			return linker.ReadInline()
+		}
+	}
+
 	_, err = linker.buf.ReadSlice('*')
 	if err != nil {
 
-		return nil, err
+		return nil, readError(err, "invalid request")
 	}
 
 	len, err := linker.ReadLenLine()
@@ -211,18 +414,23 @@ func (linker *connect) ReadOneRequest() (b [][]byte, err error) {
 		return nil, err
 	}
 
-	if len < 1 {
+	limits := linker.limits()
+	if len == 0 {
 
 		return nil, nil
 	}
+	if err = limits.checkArgs(len); err != nil {
+
+		return nil, err
+	}
 
 	b = make([][]byte, len)
-	var dataSize int
+	var dataSize, total int
 	for k := range b {
 		_, err = linker.buf.ReadSlice('$')
 		if err != nil {
 
-			return nil, err
+			return nil, readError(err, "invalid request")
 		}
 
 		dataSize, err = linker.ReadLenLine()
@@ -230,6 +438,11 @@ func (linker *connect) ReadOneRequest() (b [][]byte, err error) {
 
 			return nil, err
 		}
+		if err = limits.checkSize(dataSize, total); err != nil {
+
+			return nil, err
+		}
+		total += dataSize
 
 		b[k], err = linker.ReadSize(dataSize)
 		if err != nil {
@@ -245,17 +458,20 @@ func (linker *connect) ReadOneRequest() (b [][]byte, err error) {
 func (linker *connect) ReadLenLine() (int, error) {
 	b, err := linker.buf.ReadSlice('\n')
 	if err != nil {
-		return 0, err
+		return 0, readError(err, "invalid length")
 	}
 	l := len(b)
 	if l <= 1 {
-		return 0, errors.New("-1")
+		return 0, ProtocolError("invalid length")
 	}
-	b = b[:l - 1]
+	b = bytes.TrimSuffix(b[:l - 1], []byte("\r"))
 
 	l, err = strconv.Atoi(string(b))
+	if err != nil {
+		return 0, ProtocolError("invalid length")
+	}
 
-	return l, err
+	return l, nil
 
 }
 
@@ -265,23 +481,36 @@ func (linker *connect) read(b []byte) (int, error) {
 	return linker.buf.Read(b)
 }
 
-// ReadSize 读取指定大小数据.
+// readChunk 读取数据时第一次分配的最大字节数.
+const readChunk = 64 * 1024
+
+// ReadSize 读取指定大小数据, 内存随实际读取的数据增长, 不按声明的大小一次分配.
 func (linker *connect) ReadSize(size int) ([]byte, error) {
 	if size < 1 {
 
 		return nil, nil
 	}
 
-	b := make([]byte, size)
-	var s int
-	for s < size {
-		n, err := linker.read(b[s:])
-
+	n := size
+	if n > readChunk {
+		n = readChunk
+	}
+	b := make([]byte, 0, n)
+	for len(b) < size {
+		if len(b) == cap(b) {
+			// 已经读满, 按append的策略扩容.
+			b = append(b, 0)[:len(b)]
+		}
+		end := cap(b)
+		if end > size {
+			end = size
+		}
+		n, err := linker.read(b[len(b):end])
 		if err != nil {
 
 			return nil, err
 		}
-		s += n
+		b = b[:len(b)+n]
 	}
 
 	return b, nil
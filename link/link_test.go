@@ -0,0 +1,262 @@
+package link
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func init() {
+	RegisterHandler("TestSlow", func(conn Connect, d [][]byte) {
+		time.Sleep(20 * time.Millisecond)
+		conn.WriteString("1", "成功", "slow")
+	})
+	RegisterHandler("TestFast", func(conn Connect, d [][]byte) {
+		conn.WriteString("1", "成功", "fast")
+	})
+}
+
+// serveTest 启动一个使用指定协议的连接, 返回客户端连接.
+func serveTest(t *testing.T, proto int) (net.Conn, *bufio.Reader) {
+	srv := &server{
+		CallFunc: func(interface{}) {},
+		Proto:    proto,
+		Limits:   DefaultLimits,
+	}
+	client, rw := net.Pipe()
+	go srv.NewConn(rw).Serve()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() {
+		client.Close()
+	})
+
+	return client, bufio.NewReader(client)
+}
+
+// readLine 读取一行回复.
+func readLine(t *testing.T, r *bufio.Reader) string {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return line
+}
+
+// TestRESPOrdered RESP2协议连接按请求顺序回复, 不需要Pipeline.
+func TestRESPOrdered(t *testing.T) {
+	client, r := serveTest(t, ProtoRESP2)
+	go client.Write([]byte("*1\r\n$8\r\nTestSlow\r\nTestFast\r\n"))
+
+	for _, want := range []string{"$4\r\n", "slow\r\n", "$4\r\n", "fast\r\n"} {
+		if got := readLine(t, r); got != want {
+			t.Fatalf("reply = %q, want %q", got, want)
+		}
+	}
+}
+
+// TestRESPCommandCase RESP2协议命令名称不区分大小写.
+func TestRESPCommandCase(t *testing.T) {
+	client, r := serveTest(t, ProtoRESP2)
+	go client.Write([]byte("TESTFAST\r\n*1\r\n$8\r\ntestfast\r\n"))
+
+	for i := 0; i < 2; i++ {
+		if got := readLine(t, r); got != "$4\r\n" {
+			t.Fatalf("reply = %q, want bulk string", got)
+		}
+		readLine(t, r)
+	}
+}
+
+// TestLegacyCommandCase 原有协议命令名称区分大小写.
+func TestLegacyCommandCase(t *testing.T) {
+	client, r := serveTest(t, ProtoLegacy)
+	go client.Write([]byte("*1\n$8\ntestfast\n"))
+
+	readLine(t, r)
+	if got := readLine(t, r); got != "$3\n" {
+		t.Fatalf("reply = %q, want not found", got)
+	}
+	if got := readLine(t, r); got != "404\n" {
+		t.Fatalf("status = %q, want 404", got)
+	}
+}
+
+// testLimits 测试使用的请求大小限制.
+var testLimits = Limits{MaxArgs: 8, MaxArgSize: 256, MaxRequest: 1024}
+
+// readTest 从data中读取请求的连接.
+func readTest(proto int, data []byte) *connect {
+
+	return &connect{
+		buf:   bufio.NewReaderSize(bytes.NewReader(data), 64),
+		srv:   &server{Limits: testLimits},
+		proto: int32(proto),
+	}
+}
+
+// readAll 读取全部请求直到出错, 每个请求至少读取一个字节, 读取次数超过数据长度说明没有前进.
+func readAll(t *testing.T, linker *connect, size int) error {
+	for n := 0; n <= size; n++ {
+		b, err := linker.ReadOneRequest()
+		if err != nil {
+
+			return err
+		}
+		if len(b) > testLimits.MaxArgs {
+			t.Fatalf("args = %d, limit %d", len(b), testLimits.MaxArgs)
+		}
+		var total int
+		for _, arg := range b {
+			if len(arg) > testLimits.MaxArgSize {
+				t.Fatalf("arg size = %d, limit %d", len(arg), testLimits.MaxArgSize)
+			}
+			total += len(arg)
+		}
+		if total > testLimits.MaxRequest {
+			t.Fatalf("request size = %d, limit %d", total, testLimits.MaxRequest)
+		}
+	}
+	t.Fatalf("read more than %d requests from %d bytes", size+1, size)
+
+	return nil
+}
+
+// TestReadOneRequestMalformed 格式错误或者超过大小限制的请求返回协议错误.
+func TestReadOneRequestMalformed(t *testing.T) {
+	for _, proto := range []int{ProtoLegacy, ProtoRESP2} {
+		for _, data := range []string{
+			"*x\n",
+			"*-2\n",
+			"*\n",
+			"*9\n",
+			"*1\n$\n",
+			"*1\n$-5\n",
+			"*1\n$257\n",
+			"*1\n$99999999999999999999\n",
+			"*5\n$256\n" + string(make([]byte, 256)) + "\n$256\n" + string(make([]byte, 256)) + "\n$256\n" + string(make([]byte, 256)) + "\n$256\n" + string(make([]byte, 256)) + "\n$256\n",
+			"*1\n" + string(bytes.Repeat([]byte("1"), 100)) + "\n",
+		} {
+			err := readAll(t, readTest(proto, []byte(data)), len(data))
+			if _, ok := err.(ProtocolError); !ok {
+				t.Errorf("proto %d, request %.20q: err = %v, want ProtocolError", proto, data, err)
+			}
+		}
+	}
+
+	data := "a b c d e f g h i\r\n"
+	if _, ok := readAll(t, readTest(ProtoRESP2, []byte(data)), len(data)).(ProtocolError); !ok {
+		t.Error("inline command with too many arguments was accepted")
+	}
+	data = string(bytes.Repeat([]byte("a"), 100)) + "\r\n"
+	if _, ok := readAll(t, readTest(ProtoRESP2, []byte(data)), len(data)).(ProtocolError); !ok {
+		t.Error("inline command longer than the read buffer was accepted")
+	}
+}
+
+// FuzzReadOneRequest 任意输入不会导致panic, 超过大小限制的内存分配或者读取停止前进,
+// 只能返回协议错误或者读取到结尾.
+func FuzzReadOneRequest(f *testing.F) {
+	f.Add([]byte("*2\n$6\nAddJob\n$5\nstock\n"), false)
+	f.Add([]byte("*2\r\n$6\r\nAddJob\r\n$5\r\nstock\r\n"), true)
+	f.Add([]byte("AddJob stock data\r\nPING\n"), true)
+	f.Add([]byte("*0\n*1\n$0\n\n"), false)
+	f.Add([]byte("*1\n$1000000000\n"), false)
+	f.Add([]byte("*1000000000\n"), true)
+	f.Add([]byte("*-1\r\n$-1\r\n"), true)
+	f.Fuzz(func(t *testing.T, data []byte, resp bool) {
+		proto := ProtoLegacy
+		if resp {
+			proto = ProtoRESP2
+		}
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		err := readAll(t, readTest(proto, data), len(data))
+		runtime.ReadMemStats(&after)
+
+		if _, ok := err.(ProtocolError); !ok && err != io.EOF {
+			t.Fatalf("err = %v (%T), want ProtocolError or EOF", err, err)
+		}
+		// 读取缓存, 参数与数据之外不能按请求中的长度分配内存.
+		if n := after.TotalAlloc - before.TotalAlloc; n > uint64(64*len(data)+16*testLimits.MaxRequest) {
+			t.Fatalf("allocated %d bytes for %d bytes of input", n, len(data))
+		}
+	})
+}
+
+// TestProtocolErrorCloses 格式错误的请求回复400后关闭连接, 不会等待更多数据.
+func TestProtocolErrorCloses(t *testing.T) {
+	client, r := serveTest(t, ProtoLegacy)
+	go client.Write([]byte("*1\n$-5\n"))
+
+	readLine(t, r)
+	readLine(t, r)
+	if got := readLine(t, r); got != "400\n" {
+		t.Fatalf("status = %q, want 400", got)
+	}
+	readLine(t, r)
+	readLine(t, r)
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Fatalf("read after protocol error: %v, want EOF", err)
+	}
+}
+
+// TestCloseNotifiesAll 连接断开时同一个连接上所有等待中的请求都收到通知.
+func TestCloseNotifiesAll(t *testing.T) {
+	waiting := make(chan struct{}, 2)
+	notified := make(chan struct{}, 2)
+	RegisterHandler("TestWaitClose", func(conn Connect, d [][]byte) {
+		waiting <- struct{}{}
+		<-conn.GetC()
+		notified <- struct{}{}
+	})
+	client, _ := serveTest(t, ProtoLegacy)
+	client.Write([]byte("*1\n$13\nTestWaitClose\n*1\n$13\nTestWaitClose\n"))
+	<-waiting
+	<-waiting
+	client.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-notified:
+		case <-time.After(time.Second):
+			t.Fatalf("%d of 2 waiting requests notified", i)
+		}
+	}
+}
+
+// TestReadSizeAllocation 参数大小限制很大时, 内存随实际收到的数据增长, 不按声明的长度分配.
+func TestReadSizeAllocation(t *testing.T) {
+	linker := readTest(ProtoLegacy, append([]byte("*1\n$1073741824\n"), make([]byte, 1000)...))
+	linker.srv.Limits = Limits{MaxArgSize: 1 << 30, MaxRequest: 1 << 30}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := linker.ReadOneRequest(); err != io.EOF {
+		t.Fatalf("err = %v, want EOF", err)
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Fatalf("allocated %d bytes for a 1000 byte argument", n)
+	}
+}
+
+// TestReadSizeLarge 超过一次分配大小的参数完整读取.
+func TestReadSizeLarge(t *testing.T) {
+	value := bytes.Repeat([]byte("0123456789"), 100000)
+	data := append([]byte("*2\n$6\nAddJob\n$1000000\n"), value...)
+	linker := readTest(ProtoLegacy, append(data, '\n'))
+	linker.srv.Limits = DefaultLimits
+
+	b, err := linker.ReadOneRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 2 || !bytes.Equal(b[1], value) {
+		t.Fatalf("read %d args, value length %d", len(b), len(b[len(b)-1]))
+	}
+}
@@ -3,16 +3,22 @@ package link
 import (
 	"fmt"
 	"regexp"
+	"sort"
+	"strings"
 	"sync"
 )
 
 // HandlerFunc 注册函数.
 type HandlerFunc func(Connect, [][]byte)
 
+// InlineHandlerFunc 在读取请求的协程中执行的注册函数, 执行完成后才读取下一个请求, 用于修改连接设置.
+type InlineHandlerFunc func(Connect, [][]byte)
+
 // ServeMux 动作注册存储.
 type ServeMux struct {
-	sync.RWMutex           // 锁.
-	m map[string]*muxEntry //  动作集合.
+	sync.RWMutex                      // 锁.
+	m            map[string]*muxEntry //  动作集合.
+	names        map[string]string    // 小写命令名称对应的注册名称.
 }
 
 // muxEntry 动作信息.
@@ -35,7 +41,7 @@ func exiting(conn Connect, _ [][]byte) {
 const parent = `^[a-zA-Z_]+[a-zA-Z0-9_]*$`
 
 // RegisterHandler 注册动作.
-func (mux *ServeMux) RegisterHandler(cmd string, handler HandlerFunc) {
+func (mux *ServeMux) RegisterHandler(cmd string, handler Hander) {
 	mux.Lock()
 	defer mux.Unlock()
 
@@ -46,7 +52,8 @@ func (mux *ServeMux) RegisterHandler(cmd string, handler HandlerFunc) {
 		panic("cmd format exption")
 	}
 
-	mux.m[cmd] = &muxEntry{cmd: cmd, h: Hander(handler)}
+	mux.m[cmd] = &muxEntry{cmd: cmd, h: handler}
+	mux.names[strings.ToLower(cmd)] = cmd
 }
 
 // DeregisterHandler 注册动作.
@@ -104,16 +111,52 @@ func (mux *ServeMux) GetHandler(cmd string) Hander {
 	return notFountHandklerFunc()
 }
 
+// Canonical 不区分大小写查找已经注册的命令名称, 没有注册时原样返回.
+func (mux *ServeMux) Canonical(cmd string) string {
+	mux.RLock()
+	defer mux.RUnlock()
+
+	if name, ok := mux.names[strings.ToLower(cmd)]; ok {
+
+		return name
+	}
+
+	return cmd
+}
+
+// Commands 已经注册并且没有退出的命令, 按名称排序.
+func (mux *ServeMux) Commands() []string {
+	mux.RLock()
+	defer mux.RUnlock()
+
+	cmds := make([]string, 0, len(mux.m))
+	for cmd := range mux.m {
+		if _, ok := mux.m[mux.getExitCmd(cmd)]; ok || strings.HasPrefix(cmd, mux.getExitCmd("")) {
+			continue
+		}
+		cmds = append(cmds, cmd)
+	}
+	sort.Strings(cmds)
+
+	return cmds
+}
+
 // ServeDo 业务action.
 func (f HandlerFunc) ServeDo(conn Connect, data [][]byte) {
 	f(conn, data)
 }
 
+// ServeDo 业务action.
+func (f InlineHandlerFunc) ServeDo(conn Connect, data [][]byte) {
+	f(conn, data)
+}
+
 // NewServeMux 新建一个存储命令函数.
 func NewServeMux() *ServeMux {
 
 	return &ServeMux{
-		m: make(map[string]*muxEntry),
+		m:     make(map[string]*muxEntry),
+		names: make(map[string]string),
 	}
 }
 
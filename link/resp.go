@@ -0,0 +1,120 @@
+package link
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// 连接使用的协议.
+const (
+	ProtoLegacy = 1 // 原有协议, "\n"换行, 回复都是状态码, 说明与数据组成的字符串数组.
+	ProtoRESP2  = 2 // Redis RESP2协议, 可以使用redis-cli与Redis客户端连接.
+)
+
+// formatRESP 按RESP2协议格式数据, strs为状态码, 说明与数据.
+// 状态码1: 没有数据时为简单字符串, 一个数据时为批量字符串, 多个数据时为数组; 状态码0: nil;
+// 其他状态码: 没有数据时为错误, 有数据时为数组, 第一个元素为错误, 之后为数据, 如: 任务失败原因, 超时时已经完成的结果.
+func formatRESP(strs ...string) []byte {
+	buf := bytes.NewBuffer(nil)
+	code, msg, data := split(strs)
+	switch code {
+	case "1":
+		switch len(data) {
+		case 0:
+			fmt.Fprintf(buf, "+%s\r\n", oneLine(msg))
+		case 1:
+			fmt.Fprintf(buf, "$%d\r\n%s\r\n", len(data[0]), data[0])
+		default:
+			fmt.Fprintf(buf, "*%d\r\n", len(data))
+			for _, str := range data {
+				fmt.Fprintf(buf, "$%d\r\n%s\r\n", len(str), str)
+			}
+		}
+	case "0":
+		buf.WriteString("$-1\r\n")
+	default:
+		if len(data) > 0 {
+			fmt.Fprintf(buf, "*%d\r\n", len(data)+1)
+		}
+		fmt.Fprintf(buf, "-ERR %s %s\r\n", oneLine(code), oneLine(msg))
+		for _, str := range data {
+			fmt.Fprintf(buf, "$%d\r\n%s\r\n", len(str), str)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// writeRESPHeader 按RESP2协议写入数据头, 最后一个数据为size字节, 由调用方写入.
+func writeRESPHeader(w *bufio.Writer, size int64, strs ...string) {
+	_, _, data := split(strs)
+	if len(data) > 0 {
+		fmt.Fprintf(w, "*%d\r\n", len(data)+1)
+		for _, str := range data {
+			fmt.Fprintf(w, "$%d\r\n%s\r\n", len(str), str)
+		}
+	}
+	fmt.Fprintf(w, "$%d\r\n", size)
+}
+
+// split 拆分状态码, 说明与数据.
+func split(strs []string) (string, string, []string) {
+	switch len(strs) {
+	case 0:
+
+		return "1", "", nil
+	case 1:
+
+		return strs[0], "", nil
+	}
+
+	return strs[0], strs[1], strs[2:]
+}
+
+// oneLine 简单字符串与错误不能包含换行.
+func oneLine(str string) string {
+
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(str)
+}
+
+// peekCommand 跳过请求之间的空白, 返回下一个请求的第一个字节.
+func (linker *connect) peekCommand() (byte, error) {
+	for {
+		c, err := linker.buf.ReadByte()
+		if err != nil {
+
+			return 0, err
+		}
+		if c != '\r' && c != '\n' && c != ' ' {
+
+			return c, linker.buf.UnreadByte()
+		}
+	}
+}
+
+// ReadInline 读取一行内联命令, 参数以空白分隔, 如: AddJob stock data.
+func (linker *connect) ReadInline() ([][]byte, error) {
+	line, err := linker.buf.ReadSlice('\n')
+	if err != nil {
+
+		return nil, readError(err, "inline command too long")
+	}
+
+	fields := bytes.Fields(line)
+	if len(fields) == 0 {
+
+		return nil, nil
+	}
+	if err = linker.limits().checkArgs(len(fields)); err != nil {
+
+		return nil, err
+	}
+	b := make([][]byte, len(fields))
+	for k, field := range fields {
+		b[k] = append([]byte(nil), field...)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,45 @@
+package link
+
+import (
+	"testing"
+)
+
+// TestFormatRESP 状态码与数据转换为RESP2回复.
+func TestFormatRESP(t *testing.T) {
+	for _, c := range []struct {
+		strs []string
+		want string
+	}{
+		{[]string{"1", "成功"}, "+成功\r\n"},
+		{[]string{"1", "成功", "data"}, "$4\r\ndata\r\n"},
+		{[]string{"1", "成功", "job", "data"}, "*2\r\n$3\r\njob\r\n$4\r\ndata\r\n"},
+		{[]string{"0", "不存在"}, "$-1\r\n"},
+		{[]string{"408", "超时"}, "-ERR 408 超时\r\n"},
+		{[]string{"405", "参数\r\n错误"}, "-ERR 405 参数  错误\r\n"},
+		// 任务失败时返回失败原因.
+		{[]string{"410", "任务失败", "reason"}, "*2\r\n-ERR 410 任务失败\r\n$6\r\nreason\r\n"},
+		{[]string{"410", "任务失败", "job", "reason"}, "*3\r\n-ERR 410 任务失败\r\n$3\r\njob\r\n$6\r\nreason\r\n"},
+		// 超时时返回已经完成的结果.
+		{[]string{"408", "超时", "job-1", "1", "data", "job-2", "408", ""},
+			"*7\r\n-ERR 408 超时\r\n$5\r\njob-1\r\n$1\r\n1\r\n$4\r\ndata\r\n$5\r\njob-2\r\n$3\r\n408\r\n$0\r\n\r\n"},
+	} {
+		if got := string(formatRESP(c.strs...)); got != c.want {
+			t.Errorf("formatRESP(%q) = %q, want %q", c.strs, got, c.want)
+		}
+	}
+}
+
+// TestRESPErrorWithData 有数据的错误回复通过连接写入数组.
+func TestRESPErrorWithData(t *testing.T) {
+	RegisterHandler("TestFailed", func(conn Connect, d [][]byte) {
+		conn.WriteString("410", "任务失败", "job", "reason")
+	})
+	client, r := serveTest(t, ProtoRESP2)
+	go client.Write([]byte("TestFailed\r\n"))
+
+	for _, want := range []string{"*3\r\n", "-ERR 410 任务失败\r\n", "$3\r\n", "job\r\n", "$6\r\n", "reason\r\n"} {
+		if got := readLine(t, r); got != want {
+			t.Fatalf("reply = %q, want %q", got, want)
+		}
+	}
+}
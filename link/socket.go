@@ -1,7 +1,9 @@
 package link
 
 import (
+	"io"
 	"log"
+	"net"
 )
 
 // Hander 业务函数，当有一个请求，调用该函数.
@@ -13,9 +15,11 @@ type Hander interface {
 type Connect interface {
 	// WriteString 写入字符串数据.
 	WriteString(strs ...string) error
+	// WriteReader 写入字符串数据, 最后一个数据从r中读取size字节.
+	WriteReader(size int64, r io.Reader, strs ...string) error
 	// 读取指定大小数据.
 	ReadSize(n int) ([]byte, error)
-	// GetC 获取一个通信对象，如果网络连接关闭，获取到数据.
+	// GetC 获取一个通信对象，如果网络连接关闭，通道被关闭, 所有等待者都能收到.
 	GetC() chan interface{}
 	// ReadOneRequest 读取一个完整的请求.
 	ReadOneRequest() ([][]byte, error)
@@ -27,6 +31,20 @@ type Connect interface {
 	StopServer()
 	// 读取数据头的数据长度.
 	ReadLenLine() (int, error)
+	// SetValue 设置连接的属性.
+	SetValue(key string, val interface{})
+	// GetValue 获取连接的属性.
+	GetValue(key string) interface{}
+	// RemoteAddr 客户端地址.
+	RemoteAddr() net.Addr
+	// WriteInt 写入一个整数.
+	WriteInt(n int64) error
+	// Proto 连接使用的协议.
+	Proto() int
+	// SetProto 设置连接使用的协议, 只能在InlineHandlerFunc中调用.
+	SetProto(proto int)
+	// SetOrdered 设置是否按请求顺序处理请求并回复, 只能在InlineHandlerFunc中调用, RESP2协议总是按顺序处理.
+	SetOrdered(ordered bool)
 }
 
 // Server 启动服务.
@@ -36,11 +54,19 @@ type Server interface {
 
 // ListenAndServe 监听服务.
 func ListenAndServe(addr string, call func(d interface{}), log *log.Logger) error {
+
+	return ListenAndServeProto(addr, ProtoLegacy, call, log)
+}
+
+// ListenAndServeProto 监听服务, 连接使用指定的协议.
+func ListenAndServeProto(addr string, proto int, call func(d interface{}), log *log.Logger) error {
 	serve := &server{
 		Addr:        addr,
 		CallFunc:    call,
 		ErrorLog:    log,
 		StopMessage: make(chan interface{}, 1),
+		Proto:       proto,
+		Limits:      DefaultLimits,
 	}
 
 	return serve.ListenAndServe()
@@ -51,6 +77,17 @@ func RegisterHandler(cmd string, f func(conn Connect, d [][]byte)) {
 	DefaultServeMux.RegisterHandler(cmd, HandlerFunc(f))
 }
 
+// RegisterInlineHandler 注册在读取请求的协程中执行的函数, 函数不能阻塞.
+func RegisterInlineHandler(cmd string, f func(conn Connect, d [][]byte)) {
+	DefaultServeMux.RegisterHandler(cmd, InlineHandlerFunc(f))
+}
+
+// Commands 已经注册的命令.
+func Commands() []string {
+
+	return DefaultServeMux.Commands()
+}
+
 // DeregisterHandler 注册函数.
 func DeregisterHandler(cmd string) {
 	DefaultServeMux.DeregisterHandler(cmd)
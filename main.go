@@ -1,44 +1,77 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"math"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"./cache"
 	"./h32"
+	"./journal"
 	"./link"
 	"./queue"
+	"./store"
 )
 
 // Config 配置系统.
 type Config struct {
-	Address  string
-	Log      *log.Logger
-	Filename string
+	Address       string
+	RESPAddress   string // RESP2协议监听地址, 可以使用redis-cli连接, 为空不监听.
+	Log           *log.Logger
+	Filename      string
+	AdminPassword string        // 管理员密码, 为空不允许管理员认证.
+	Journal       string        // 持久化日志文件, 为空不持久化.
+	Fsync         string        // 日志同步到磁盘的策略: always|everysec|no.
+	Snapshot      time.Duration // 定时生成快照并压缩日志的周期, 0不定时生成.
+	Store         string        // 数据存储引擎: memory|disk.
+	Data          string        // 数据目录.
+	Spill         int           // 任务结果超过该字节数时写入文件, 小于0不写入文件.
+	MaxItems      int           // 缓存最大任务结果数, 0不限制.
+	MaxMemory     int64         // 缓存最大字节数, 0不限制.
+	MaxWait       time.Duration // GetReturn与ReserveWait最长等待时间, 0不限制.
+	MaxArgs       int           // 一个请求最多参数个数, 0不限制.
+	MaxArgSize    int           // 一个请求参数最大字节数, 0不限制.
+	MaxRequest    int           // 一个请求最大字节数, 0不限制.
 }
 
-// DefaultQueue 队列对象.
-var DefaultQueue = queue.NewQueue(time.Minute * 10)
+// DefaultQueue 队列对象, 启动服务时根据配置创建.
+var DefaultQueue queue.Queue
 
 // DefaultH32 默认.
 var DefaultH32 = h32.DefaultHash
 
-// DefaultCache 缓存对象.
-var DefaultCache = cache.NewCache(time.Minute * 10)
+// DefaultCache 缓存对象, 启动服务时根据配置创建.
+var DefaultCache cache.Cache
+
+// DefaultJournal 持久化日志, 没有配置时为nil.
+var DefaultJournal journal.Journal
+
+// DefaultTimeout GetReturn默认等待时间.
+const DefaultTimeout = time.Minute
+
+// Version 服务版本.
+const Version = "1.0.0"
+
+// ResultTTL 任务结果默认保留时间.
+const ResultTTL = time.Minute * 10
 
 // DefaultConfig 默认配置.
 var DefaultConfig = NewConfig(":8989", "/Users/liaozhouping/Desktop/task.log")
 
 // main 主函数.
 func main() {
+	DefaultConfig.Parse(os.Args)
 	var cmd string
 	if len(os.Args) > 1 {
 		cmd = os.Args[1]
@@ -111,24 +144,133 @@ func Stop() {
 func StartServer() {
 	DefaultConfig.Init()
 	runtime.GOMAXPROCS(runtime.NumCPU())
+	// 创建存储引擎.
+	qs, err := store.Open(DefaultConfig.Store, filepath.Join(DefaultConfig.Data, "queue.db"))
+	if err != nil {
+		fmt.Println("Fail to open store", err.Error(), "cServer start Failed")
+		os.Exit(1)
+	}
+	defer qs.Close()
+	cs, err := store.Open(DefaultConfig.Store, filepath.Join(DefaultConfig.Data, "cache.db"))
+	if err != nil {
+		fmt.Println("Fail to open store", err.Error(), "cServer start Failed")
+		os.Exit(1)
+	}
+	defer cs.Close()
+	DefaultQueue = queue.NewQueue(time.Minute * 10, qs)
+	DefaultCache = cache.NewCache(cs)
+	if DefaultConfig.Spill >= 0 {
+		files, err := store.OpenFiles(filepath.Join(DefaultConfig.Data, "results"))
+		if err != nil {
+			fmt.Println("Fail to open store", err.Error(), "cServer start Failed")
+			os.Exit(1)
+		}
+		DefaultCache.SetFiles(files, DefaultConfig.Spill)
+	}
+	DefaultCache.SetLimit(DefaultConfig.MaxItems, DefaultConfig.MaxMemory)
+	DefaultQueue.SetFailHandler(Failed)
+	// 从日志恢复任务与结果数据.
+	if DefaultConfig.Journal != "" {
+		j, err := journal.Open(DefaultConfig.Journal, DefaultConfig.Fsync, DefaultConfig.Log)
+		if err == nil {
+			err = j.Replay(Replay)
+		}
+		if err != nil {
+			fmt.Println("Fail to replay journal", err.Error(), "cServer start Failed")
+			os.Exit(1)
+		}
+		DefaultQueue.SetJournal(j)
+		DefaultCache.SetJournal(j)
+		DefaultJournal = j
+		defer j.Close()
+		if DefaultConfig.Snapshot > 0 {
+			go StartSnapshot(DefaultConfig.Snapshot)
+		}
+	}
+	// 重建队列, 删除存储引擎中没有任务信息的数据.
+	if err = DefaultQueue.Recover(); err == nil {
+		err = DefaultCache.Recover()
+	}
+	if err != nil {
+		fmt.Println("Fail to recover", err.Error(), "cServer start Failed")
+		os.Exit(1)
+	}
 	fmt.Println("启动服务")
 	//// 注册动作.
 	// AddJob 向队列添加任务.
 	link.RegisterHandler("AddJob", AddJob)
 	// GetReturn 获取任务完成后的结果.
 	link.RegisterHandler("GetReturn", GetReturn)
+	// GetReturnAny 获取多个任务中第一个完成的结果.
+	link.RegisterHandler("GetReturnAny", GetReturnAny)
+	// GetReturnAll 获取多个任务全部完成后的结果.
+	link.RegisterHandler("GetReturnAll", GetReturnAll)
 	// Usr1 队列中，添加通知.
 	link.RegisterHandler("Usr1", Usr1)
 	// GetJob 获取任务.
 	link.RegisterHandler("GetJob", GetJob)
+	// ReserveWait 等待并获取任务.
+	link.RegisterHandler("ReserveWait", ReserveWait)
+	// ReserveAny 从多个队列中等待并获取任务.
+	link.RegisterHandler("ReserveAny", ReserveAny)
 	// SetReturn 设置任务完成结果.
 	link.RegisterHandler("SetReturn", SetReturn)
+	// DelReturn 删除任务结果.
+	link.RegisterHandler("DelReturn", DelReturn)
+	// Touch 延长任务执行时间.
+	link.RegisterHandler("Touch", Touch)
+	// Release 将任务放回队列.
+	link.RegisterHandler("Release", Release)
+	// Fail 任务执行失败.
+	link.RegisterHandler("Fail", Fail)
+	// Bury 埋葬任务.
+	link.RegisterHandler("Bury", Bury)
+	// Kick 将埋葬的任务放回队列.
+	link.RegisterHandler("Kick", Kick)
+	// PeekReady 查看队列中第一个等待执行的任务.
+	link.RegisterHandler("PeekReady", PeekReady)
+	// PeekReserved 查看队列中正在执行的任务.
+	link.RegisterHandler("PeekReserved", PeekReserved)
+	// PeekBuried 查看队列中第一个埋葬的任务.
+	link.RegisterHandler("PeekBuried", PeekBuried)
+	// JobInfo 查看任务信息.
+	link.RegisterHandler("JobInfo", JobInfo)
+	// TubeSet 设置队列配置.
+	link.RegisterHandler("TubeSet", TubeSet)
+	// StatsTube 获取队列统计信息.
+	link.RegisterHandler("StatsTube", StatsTube)
+	// StatsCache 获取结果缓存统计信息.
+	link.RegisterHandler("StatsCache", StatsCache)
+	// Auth 管理员认证.
+	link.RegisterHandler("Auth", Auth)
+	// Snapshot 生成快照并压缩日志.
+	link.RegisterHandler("Snapshot", Snapshot)
 	// StopServer 关闭服务.
 	link.RegisterHandler("StopServer", StopServer)
 	// Status 获取服务状态.
 	link.RegisterHandler("Status", Status)
-	// 启动网络服务.
-	err := link.ListenAndServe(DefaultConfig.Address, EOF, DefaultConfig.Log)
+	// Ping 检查连接.
+	link.RegisterHandler("Ping", Ping)
+	// Pipeline 设置连接按请求顺序回复.
+	link.RegisterInlineHandler("Pipeline", Pipeline)
+	// Hello 协议协商.
+	link.RegisterInlineHandler("Hello", Hello)
+	link.DefaultLimits = link.Limits{
+		MaxArgs:    DefaultConfig.MaxArgs,
+		MaxArgSize: DefaultConfig.MaxArgSize,
+		MaxRequest: DefaultConfig.MaxRequest,
+	}
+	// 启动网络服务, 任意一个服务退出时退出.
+	errc := make(chan error, 2)
+	if DefaultConfig.RESPAddress != "" {
+		go func() {
+			errc <- link.ListenAndServeProto(DefaultConfig.RESPAddress, link.ProtoRESP2, EOF, DefaultConfig.Log)
+		}()
+	}
+	go func() {
+		errc <- link.ListenAndServe(DefaultConfig.Address, EOF, DefaultConfig.Log)
+	}()
+	err = <-errc
 	// 服务退出，一些注册动作不能继续使用
 	logf(err)
 	fmt.Println("完成退出")
@@ -137,13 +279,47 @@ func StartServer() {
 // NewConfig 创建默认配置.
 func NewConfig(addr, logFilename string) *Config {
 	c := &Config{
-		Address:  addr,
-		Filename: logFilename,
+		Address:    addr,
+		Filename:   logFilename,
+		Fsync:      journal.SyncEverySec,
+		Store:      store.Memory,
+		Data:       "./data",
+		Spill:      64 * 1024,
+		MaxWait:    time.Minute * 5,
+		MaxArgs:    link.DefaultLimits.MaxArgs,
+		MaxArgSize: link.DefaultLimits.MaxArgSize,
+		MaxRequest: link.DefaultLimits.MaxRequest,
 	}
 
 	return c
 }
 
+// Parse 解析命令行参数, 如: task start -addr :8989 -admin password.
+func (conf *Config) Parse(args []string) {
+	if len(args) < 2 {
+		return
+	}
+
+	fs := flag.NewFlagSet(args[0], flag.ExitOnError)
+	fs.StringVar(&conf.Address, "addr", conf.Address, "监听地址")
+	fs.StringVar(&conf.RESPAddress, "resp", conf.RESPAddress, "RESP2协议监听地址, 如: :6380, 为空不监听")
+	fs.StringVar(&conf.Filename, "log", conf.Filename, "日志文件")
+	fs.StringVar(&conf.AdminPassword, "admin", conf.AdminPassword, "管理员密码")
+	fs.StringVar(&conf.Journal, "journal", conf.Journal, "持久化日志文件")
+	fs.StringVar(&conf.Fsync, "fsync", conf.Fsync, "日志同步策略: always|everysec|no")
+	fs.DurationVar(&conf.Snapshot, "snapshot", conf.Snapshot, "定时生成快照的周期, 如: 1h")
+	fs.StringVar(&conf.Store, "store", conf.Store, "数据存储引擎: memory|disk")
+	fs.StringVar(&conf.Data, "data", conf.Data, "数据目录")
+	fs.IntVar(&conf.Spill, "spill", conf.Spill, "任务结果超过该字节数时写入文件, 小于0不写入文件")
+	fs.IntVar(&conf.MaxItems, "maxitems", conf.MaxItems, "缓存最大任务结果数, 0不限制")
+	fs.Int64Var(&conf.MaxMemory, "maxmemory", conf.MaxMemory, "缓存最大字节数, 0不限制")
+	fs.DurationVar(&conf.MaxWait, "maxwait", conf.MaxWait, "GetReturn与ReserveWait最长等待时间, 如: 5m, 0不限制")
+	fs.IntVar(&conf.MaxArgs, "maxargs", conf.MaxArgs, "一个请求最多参数个数, 0不限制")
+	fs.IntVar(&conf.MaxArgSize, "maxargsize", conf.MaxArgSize, "一个请求参数最大字节数, 0不限制")
+	fs.IntVar(&conf.MaxRequest, "maxrequest", conf.MaxRequest, "一个请求最大字节数, 0不限制")
+	fs.Parse(args[2:])
+}
+
 // Init 初始化文件日志.
 func (conf *Config) Init() {
 	var l *log.Logger
@@ -177,27 +353,26 @@ func isDirExists(path string) bool {
 	return fi.IsDir()
 }
 
-// GetReturn 获取数据.
+// GetReturn 获取数据, 可以指定等待超时, 如: 3000(毫秒), 3000ms, 3s.
 func GetReturn(conn link.Connect, d [][]byte) {
-	if len(d) < 2 {
+	l := len(d)
+	if l < 2 {
 		ERRVAR(conn)
 		return
 	}
-	timeout := time.Minute
-	if d[2] != nil {
-		tmp, err := strconv.Atoi(string(d[2]))
-		if err != nil {
-			timeout = time.Second * time.Duration(tmp)
-		}
+	var b []byte
+	if l > 2 {
+		b = d[2]
+	}
+	timeout, err := parseWait(b)
+	if err != nil {
+		ERRVAR(conn)
+		return
 	}
 	key := string(d[1])
-	var val []byte
-	var err error
-	var ok bool
-	ok = DefaultQueue.Exists(key)
-	if ok {
-		val, err = DefaultCache.GetAndTimeOut(key, timeout, conn.GetC())
-		if err != nil {
+	if timeout > 0 && DefaultQueue.Exists(key) {
+		err := DefaultCache.Wait(key, timeout, conn.GetC())
+		if err != nil && err != cache.ErrFailed {
 			if err.Error() == "timeout" {
 				conn.WriteString("408", "超时")
 			} else if (err.Error() == "EOF") {
@@ -206,19 +381,118 @@ func GetReturn(conn link.Connect, d [][]byte) {
 				SystemERR(conn, err)
 				logf(err)
 			}
-		} else {
-			conn.WriteString("1", "成功", string(val))
+
+			return
+		}
+	}
+
+	WriteReturn(conn, key)
+}
+
+// WriteReturn 写入任务结果, 结果直接从存储中读取写入连接, strs写在结果之前.
+func WriteReturn(conn link.Connect, key string, strs ...string) {
+	if r, size, ok := DefaultCache.Open(key); ok {
+		defer r.Close()
+		conn.WriteReader(size, r, append([]string{"1", "成功"}, strs...)...)
+	} else if val, ok := DefaultCache.Reason(key); ok {
+		conn.WriteString(append(append([]string{"410", "任务失败"}, strs...), string(val))...)
+	} else {
+		conn.WriteString(append([]string{"0", "不存在"}, strs...)...)
+	}
+}
+
+// GetReturnAny 等待多个任务中任意一个完成, 返回第一个完成任务的key与结果, 最后一个参数为等待超时.
+func GetReturnAny(conn link.Connect, d [][]byte) {
+	l := len(d)
+	if l < 3 {
+		ERRVAR(conn)
+		return
+	}
+	timeout, err := parseWait(d[l-1])
+	if err != nil {
+		ERRVAR(conn)
+		return
+	}
+	keys := make([]string, 0, l-2)
+	var wait bool
+	for _, b := range d[1 : l-1] {
+		key := string(b)
+		keys = append(keys, key)
+		wait = wait || DefaultQueue.Exists(key)
+	}
+	if !wait {
+		// 没有未完成的任务, 只检查已经存在的结果.
+		timeout = 0
+	}
+
+	key, err := DefaultCache.WaitAny(keys, timeout, conn.GetC())
+	if err != nil && err != cache.ErrFailed {
+		if err.Error() == "timeout" && !wait {
+			conn.WriteString("0", "不存在")
+		} else if err.Error() == "timeout" {
+			conn.WriteString("408", "超时")
+		} else if err.Error() != "EOF" {
+			SystemERR(conn, err)
+			logf(err)
 		}
 
 		return
 	}
 
-	val, ok = DefaultCache.Get(key)
-	if ok {
-		conn.WriteString("1", "成功", string(val))
-	} else {
-		conn.WriteString("0", "不存在")
+	WriteReturn(conn, key, key)
+}
+
+// GetReturnAll 等待多个任务全部完成, 超时返回已经完成的结果, 每个任务返回key、状态与结果, 最后一个参数为等待超时.
+// 状态: 1成功, 410任务失败, 408未完成, 0不存在.
+func GetReturnAll(conn link.Connect, d [][]byte) {
+	l := len(d)
+	if l < 3 {
+		ERRVAR(conn)
+		return
+	}
+	timeout, err := parseWait(d[l-1])
+	if err != nil {
+		ERRVAR(conn)
+		return
+	}
+	keys := make([]string, 0, l-2)
+	pending := make([]string, 0, l-2)
+	for _, b := range d[1 : l-1] {
+		key := string(b)
+		keys = append(keys, key)
+		if DefaultQueue.Exists(key) {
+			pending = append(pending, key)
+		}
+	}
+
+	res := []string{"1", "成功"}
+	if len(pending) > 0 {
+		err = DefaultCache.WaitAll(pending, timeout, conn.GetC())
+		if err != nil {
+			if err.Error() == "EOF" {
+				return
+			}
+			if err.Error() != "timeout" {
+				SystemERR(conn, err)
+				logf(err)
+				return
+			}
+			res = []string{"408", "超时"}
+		}
 	}
+
+	for _, key := range keys {
+		if val, ok := DefaultCache.Get(key); ok {
+			res = append(res, key, "1", string(val))
+		} else if val, ok := DefaultCache.Reason(key); ok {
+			res = append(res, key, "410", string(val))
+		} else if DefaultQueue.Exists(key) {
+			res = append(res, key, "408", "")
+		} else {
+			res = append(res, key, "0", "")
+		}
+	}
+	conn.WriteString(res...)
 }
 
 // StopServer 停止服务.
@@ -227,11 +501,71 @@ func StopServer(conn link.Connect, _ [][]byte) {
 	conn.StopServer()
 }
 
+// Ping 检查连接, RESP2协议回复PONG.
+func Ping(conn link.Connect, _ [][]byte) {
+	conn.WriteString("1", "PONG")
+}
+
 // Status 服务状态.
 func Status(conn link.Connect, _ [][]byte) {
 	conn.WriteString("1", "运行中")
 }
 
+// Hello 协议协商, 返回服务支持的协议版本, 命令与功能.
+// 指定协议版本时, 本次与之后的回复都使用该协议.
+func Hello(conn link.Connect, d [][]byte) {
+	if len(d) > 1 {
+		proto, err := strconv.Atoi(string(d[1]))
+		if err != nil {
+			ERRVAR(conn)
+			return
+		}
+		if proto != link.ProtoLegacy && proto != link.ProtoRESP2 {
+			conn.WriteString("405", "不支持的协议版本")
+			return
+		}
+		conn.SetProto(proto)
+	}
+
+	features := []string{"pipeline", "resp2"}
+	if DefaultConfig.AdminPassword != "" {
+		features = append(features, "auth")
+	}
+	if DefaultJournal != nil {
+		features = append(features, "journal")
+	}
+	conn.WriteString("1", "成功",
+		"server", "wing-task",
+		"version", Version,
+		"proto", strconv.Itoa(conn.Proto()),
+		"protos", strconv.Itoa(link.ProtoLegacy)+","+strconv.Itoa(link.ProtoRESP2),
+		"commands", strings.Join(link.Commands(), ","),
+		"features", strings.Join(features, ","))
+}
+
+// Pipeline 设置连接是否按请求顺序处理请求并回复, 1按顺序, 0并发处理.
+// 按顺序处理时客户端可以一次发送多个请求, 再按发送顺序读取回复.
+func Pipeline(conn link.Connect, d [][]byte) {
+	if len(d) < 2 {
+		ERRVAR(conn)
+		return
+	}
+	switch string(d[1]) {
+	case "1":
+		conn.SetOrdered(true)
+	case "0":
+		if conn.Proto() == link.ProtoRESP2 {
+			conn.WriteString("405", "RESP2协议总是按顺序回复")
+			return
+		}
+		conn.SetOrdered(false)
+	default:
+		ERRVAR(conn)
+		return
+	}
+	conn.WriteString("1", "成功")
+}
+
 // Usr1 有数据通知.
 func Usr1(conn link.Connect, d [][]byte) {
 	if len(d) < 2 {
@@ -256,8 +590,43 @@ func AddJob(conn link.Connect, d [][]byte) {
 		ERRVAR(conn)
 		return
 	}
+	var ttr, delay time.Duration
+	var err error
+	if l > 3 {
+		ttr, err = parseSeconds(d[3])
+		if err != nil {
+			ERRVAR(conn)
+			return
+		}
+	}
+	if l > 4 {
+		delay, err = parseDelay(d[4])
+		if err != nil {
+			ERRVAR(conn)
+			return
+		}
+	}
+	pri := queue.DefaultPriority
+	if l > 5 {
+		var n uint64
+		n, err = strconv.ParseUint(string(d[5]), 10, 32)
+		if err != nil {
+			ERRVAR(conn)
+			return
+		}
+		pri = uint32(n)
+	}
+	result := queue.ResultTTL
+	if l > 6 {
+		var ok bool
+		result, ok = parseResult(d[6])
+		if !ok {
+			ERRVAR(conn)
+			return
+		}
+	}
 	key := DefaultH32.GetUID()
-	err := DefaultQueue.Join(string(d[1]), key, d[2])
+	err = DefaultQueue.Join(string(d[1]), key, d[2], ttr, delay, pri, result)
 	if err != nil {
 		SystemERR(conn, err)
 		logf(err)
@@ -281,25 +650,452 @@ func GetJob(conn link.Connect, d [][]byte) {
 	}
 }
 
-// SetReturn 设置数据.
+// ReserveWait 获取任务对象, 队列中没有任务时等待, 最后一个参数为等待超时.
+func ReserveWait(conn link.Connect, d [][]byte) {
+	l := len(d)
+	if l < 2 {
+		ERRVAR(conn)
+		return
+	}
+	var b []byte
+	if l > 2 {
+		b = d[2]
+	}
+	timeout, err := parseWait(b)
+	if err != nil {
+		ERRVAR(conn)
+		return
+	}
+
+	key, val, err := DefaultQueue.ReserveWait(string(d[1]), conn, timeout, conn.GetC())
+	if err == nil {
+		conn.WriteString("1", "成功", key, string(val))
+	} else if err.Error() == "timeout" {
+		conn.WriteString("408", "超时")
+	} else if err.Error() != "EOF" {
+		SystemERR(conn, err)
+		logf(err)
+	}
+}
+
+// ReserveAny 从多个队列中获取任务对象, 所有队列都没有任务时等待, 最后一个参数为等待超时.
+// 队列名称后可以指定权重, 如: stock:3, 指定权重时按加权轮询获取, 否则按队列的先后顺序获取.
+func ReserveAny(conn link.Connect, d [][]byte) {
+	l := len(d)
+	if l < 3 {
+		ERRVAR(conn)
+		return
+	}
+	timeout, err := parseWait(d[l-1])
+	if err != nil {
+		ERRVAR(conn)
+		return
+	}
+	tubes := make([]string, 0, l-2)
+	weights := make([]int, 0, l-2)
+	var weighted bool
+	for _, b := range d[1 : l-1] {
+		tube, weight, ok := parseWeight(string(b))
+		if !ok {
+			ERRVAR(conn)
+			return
+		}
+		weighted = weighted || weight > 0
+		if weight < 1 {
+			weight = 1
+		}
+		tubes = append(tubes, tube)
+		weights = append(weights, weight)
+	}
+
+	order := tubes
+	var w *queue.Weighted
+	if weighted {
+		// 每个连接保存自己的轮询状态.
+		w, _ = conn.GetValue("weighted").(*queue.Weighted)
+		if w == nil || !w.Match(tubes, weights) {
+			w = queue.NewWeighted(tubes, weights)
+			conn.SetValue("weighted", w)
+		}
+		order = w.Order()
+	}
+
+	tube, key, val, err := DefaultQueue.ReserveAny(order, conn, timeout, conn.GetC())
+	if err == nil {
+		if w != nil {
+			w.Served(tube)
+		}
+		conn.WriteString("1", "成功", key, string(val), tube)
+	} else if err.Error() == "timeout" {
+		conn.WriteString("408", "超时")
+	} else if err.Error() != "EOF" {
+		SystemERR(conn, err)
+		logf(err)
+	}
+}
+
+// SetReturn 设置数据, 只有预订任务的连接或者管理员可以设置.
+// 可以指定结果保留秒数, 按添加任务时的结果保留策略保存.
 func SetReturn(conn link.Connect, d [][]byte) {
-	if len(d) < 3 {
+	l := len(d)
+	if l < 3 {
 		ERRVAR(conn)
+		return
+	}
+	ttl := ResultTTL
+	if l > 3 {
+		var err error
+		ttl, err = parseSeconds(d[3])
+		if err != nil || ttl == 0 {
+			ERRVAR(conn)
+			return
+		}
 	}
 	key := string(d[1])
-	var ok bool
-	err := DefaultCache.Set(key, d[2], time.Minute * 10)
+	info, ok := DefaultQueue.Info(key)
+	if !ok {
+		QueueERR(conn, queue.ErrNotFound)
+		return
+	}
+	// 在任务锁内检查预订并保存结果, 预订过期的Worker不会覆盖新Worker的结果.
+	err := DefaultQueue.Finish(key, Owner(conn), func() error {
+
+		return DefaultCache.Set(key, d[2], resultLifespan(info.Result, ttl), info.Result == queue.ResultOnce)
+	})
+	QueueERR(conn, err)
+}
+
+// Auth 管理员认证.
+func Auth(conn link.Connect, d [][]byte) {
+	if len(d) < 2 {
+		ERRVAR(conn)
+		return
+	}
+
+	if DefaultConfig.AdminPassword == "" || string(d[1]) != DefaultConfig.AdminPassword {
+		conn.WriteString("403", "认证失败")
+		return
+	}
+	conn.SetValue("admin", true)
+	conn.WriteString("1", "成功")
+}
+
+// Owner 获取操作任务的连接标示, 管理员返回queue.Admin.
+func Owner(conn link.Connect) interface{} {
+	if admin, ok := conn.GetValue("admin").(bool); ok && admin {
+
+		return queue.Admin
+	}
+
+	return conn
+}
+
+// Touch 延长任务执行时间.
+func Touch(conn link.Connect, d [][]byte) {
+	if len(d) < 2 {
+		ERRVAR(conn)
+		return
+	}
+
+	if DefaultQueue.Touch(string(d[1]), conn) {
+		conn.WriteString("1", "成功")
+	} else {
+		conn.WriteString("404", "不存在")
+	}
+}
+
+// Release 将任务放回队列, 可以指定延迟秒数.
+func Release(conn link.Connect, d [][]byte) {
+	if len(d) < 2 {
+		ERRVAR(conn)
+		return
+	}
+
+	var delay time.Duration
+	if len(d) > 2 {
+		var err error
+		delay, err = parseDelay(d[2])
+		if err != nil {
+			ERRVAR(conn)
+			return
+		}
+	}
+
+	err := DefaultQueue.Release(string(d[1]), Owner(conn), delay)
+	QueueERR(conn, err)
+}
+
+// Fail 任务执行失败, 记录失败原因.
+func Fail(conn link.Connect, d [][]byte) {
+	if len(d) < 3 {
+		ERRVAR(conn)
+		return
+	}
+
+	err := DefaultQueue.Fail(string(d[1]), Owner(conn), string(d[2]))
+	QueueERR(conn, err)
+}
+
+// Bury 埋葬任务, 等待人工处理.
+func Bury(conn link.Connect, d [][]byte) {
+	if len(d) < 2 {
+		ERRVAR(conn)
+		return
+	}
+
+	var reason string
+	if len(d) > 2 {
+		reason = string(d[2])
+	}
+	err := DefaultQueue.Bury(string(d[1]), Owner(conn), reason)
+	QueueERR(conn, err)
+}
+
+// Kick 将队列中最多n个埋葬的任务放回队列, 返回放回的任务数.
+func Kick(conn link.Connect, d [][]byte) {
+	if len(d) < 3 {
+		ERRVAR(conn)
+		return
+	}
+
+	n, err := strconv.Atoi(string(d[2]))
+	if err != nil || n < 0 {
+		ERRVAR(conn)
+		return
+	}
+	n, err = DefaultQueue.Kick(string(d[1]), n)
 	if err != nil {
 		SystemERR(conn, err)
 		logf(err)
+	} else {
+		conn.WriteInt(int64(n))
 	}
-	ok = DefaultQueue.Finish(key, conn)
-	if !ok {
+}
+
+// PeekReady 查看队列中第一个等待执行的任务.
+func PeekReady(conn link.Connect, d [][]byte) {
+	peek(conn, d, queue.READY)
+}
+
+// PeekReserved 查看队列中正在执行的任务.
+func PeekReserved(conn link.Connect, d [][]byte) {
+	peek(conn, d, queue.RESERVED)
+}
+
+// PeekBuried 查看队列中第一个埋葬的任务.
+func PeekBuried(conn link.Connect, d [][]byte) {
+	peek(conn, d, queue.BURIED)
+}
+
+// peek 查看队列中指定状态的任务.
+func peek(conn link.Connect, d [][]byte, status uint8) {
+	if len(d) < 2 {
+		ERRVAR(conn)
+		return
+	}
+
+	info, ok := DefaultQueue.Peek(string(d[1]), status)
+	if ok {
+		WriteJobInfo(conn, info)
+	} else {
+		conn.WriteString("0", "NULL")
+	}
+}
+
+// JobInfo 查看任务信息.
+func JobInfo(conn link.Connect, d [][]byte) {
+	if len(d) < 2 {
+		ERRVAR(conn)
+		return
+	}
+
+	info, ok := DefaultQueue.Info(string(d[1]))
+	if ok {
+		WriteJobInfo(conn, info)
+	} else {
 		conn.WriteString("404", "不存在")
+	}
+}
+
+// WriteJobInfo 返回任务信息.
+func WriteJobInfo(conn link.Connect, info *queue.JobInfo) {
+	var addr string
+	if c, ok := info.Conn.(link.Connect); ok {
+		addr = c.RemoteAddr().String()
+	}
+	conn.WriteString("1", "成功",
+		"key", info.Key,
+		"data", string(info.Value),
+		"state", queue.StatusName(info.Status),
+		"tube", info.Tube,
+		"age", strconv.Itoa(int(info.Age / time.Second)),
+		"attempts", strconv.Itoa(info.Attempts),
+		"reserved-by", addr,
+		"reason", info.Reason,
+	)
+}
+
+// TubeSet 设置队列配置.
+func TubeSet(conn link.Connect, d [][]byte) {
+	if len(d) < 4 {
+		ERRVAR(conn)
+		return
+	}
+
+	tube := string(d[1])
+	var err error
+	switch strings.ToLower(string(d[2])) {
+	case "ttr":
+		var ttr time.Duration
+		ttr, err = parseSeconds(d[3])
+		if err != nil {
+			ERRVAR(conn)
+			return
+		}
+		err = DefaultQueue.SetTTR(tube, ttr)
+	case "attempts":
+		var n int
+		n, err = strconv.Atoi(string(d[3]))
+		if err != nil || n < 0 {
+			ERRVAR(conn)
+			return
+		}
+		err = DefaultQueue.SetMaxAttempts(tube, n)
+	default:
+		ERRVAR(conn)
+		return
+	}
+
+	if err != nil {
+		SystemERR(conn, err)
+		logf(err)
 	} else {
 		conn.WriteString("1", "成功")
 	}
+}
+
+// StatsTube 获取队列统计信息, 返回等待执行任务数与各优先级任务数.
+func StatsTube(conn link.Connect, d [][]byte) {
+	if len(d) < 2 {
+		ERRVAR(conn)
+		return
+	}
 
+	stats, ok := DefaultQueue.StatsTube(string(d[1]))
+	if !ok {
+		conn.WriteString("404", "不存在")
+		return
+	}
+
+	pris := make([]int, 0, len(stats.Priority))
+	for pri := range stats.Priority {
+		pris = append(pris, int(pri))
+	}
+	sort.Ints(pris)
+	strs := []string{"1", "成功", "ready", strconv.Itoa(stats.Ready)}
+	for _, pri := range pris {
+		strs = append(strs, "pri."+strconv.Itoa(pri), strconv.Itoa(stats.Priority[uint32(pri)]))
+	}
+	conn.WriteString(strs...)
+}
+
+// StatsCache 获取结果缓存统计信息.
+func StatsCache(conn link.Connect, _ [][]byte) {
+	stats := DefaultCache.Stats()
+	conn.WriteString("1", "成功",
+		"items", strconv.Itoa(stats.Items),
+		"bytes", strconv.FormatInt(stats.Bytes, 10),
+		"max-items", strconv.Itoa(stats.MaxItems),
+		"max-bytes", strconv.FormatInt(stats.MaxBytes, 10),
+		"kept", strconv.Itoa(stats.Kept),
+		"kept-bytes", strconv.FormatInt(stats.KeptBytes, 10),
+		"evictions", strconv.FormatInt(stats.Evictions, 10),
+		"evicted-bytes", strconv.FormatInt(stats.EvictedBytes, 10))
+}
+
+// parseSeconds 解析秒数.
+func parseSeconds(b []byte) (time.Duration, error) {
+	n, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, errors.New("negative seconds")
+	}
+
+	return time.Second * time.Duration(n), nil
+}
+
+// parseTimeout 解析等待超时, 支持ms与s单位, 没有单位为毫秒.
+func parseTimeout(b []byte) (time.Duration, error) {
+	unit := time.Millisecond
+	str := string(b)
+	if strings.HasSuffix(str, "ms") {
+		str = str[:len(str)-2]
+	} else if strings.HasSuffix(str, "s") {
+		str = str[:len(str)-1]
+		unit = time.Second
+	}
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > int64(math.MaxInt64/unit) {
+		return 0, errors.New("invalid timeout")
+	}
+
+	return unit * time.Duration(n), nil
+}
+
+// parseWait 解析GetReturn与ReserveWait等待超时, 为空使用默认等待时间, 超过最长等待时间时使用最长等待时间.
+func parseWait(b []byte) (time.Duration, error) {
+	if len(b) == 0 {
+		return DefaultTimeout, nil
+	}
+	timeout, err := parseTimeout(b)
+	if err != nil {
+		return 0, err
+	}
+	if DefaultConfig.MaxWait > 0 && timeout > DefaultConfig.MaxWait {
+		timeout = DefaultConfig.MaxWait
+	}
+
+	return timeout, nil
+}
+
+// parseWeight 解析队列名称与权重, 如: stock:3, 没有权重时权重为0.
+func parseWeight(str string) (string, int, bool) {
+	i := strings.LastIndex(str, ":")
+	if i < 0 {
+		return str, 0, true
+	}
+	n, err := strconv.Atoi(str[i+1:])
+	if err != nil {
+		// 队列名称中包含":".
+		return str, 0, true
+	}
+	if n < 1 || i == 0 {
+		return "", 0, false
+	}
+
+	return str[:i], n, true
+}
+
+// parseDelay 解析延迟时间, 数字为延迟秒数, "@"开头为unix时间戳.
+func parseDelay(b []byte) (time.Duration, error) {
+	if len(b) > 0 && b[0] == '@' {
+		n, err := strconv.ParseInt(string(b[1:]), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		if delay := time.Unix(n, 0).Sub(time.Now()); delay > 0 {
+			return delay, nil
+		}
+
+		return 0, nil
+	}
+
+	return parseSeconds(b)
 }
 
 // ERRVAR 传参错误.
@@ -307,6 +1103,125 @@ func ERRVAR(conn link.Connect) {
 	conn.WriteString("405", "参数错误")
 }
 
+// Failed 任务失败回调函数, 通知等待结果的客户端.
+func Failed(key, reason string, result uint8) {
+	err := DefaultCache.Fail(key, []byte(reason), resultLifespan(result, ResultTTL), result == queue.ResultOnce)
+	logf(err)
+}
+
+// DelReturn 删除任务结果.
+func DelReturn(conn link.Connect, d [][]byte) {
+	if len(d) < 2 {
+		ERRVAR(conn)
+		return
+	}
+
+	ok, err := DefaultCache.Delete(string(d[1]))
+	if err != nil {
+		SystemERR(conn, err)
+		logf(err)
+	} else if ok {
+		conn.WriteString("1", "成功")
+	} else {
+		conn.WriteString("0", "不存在")
+	}
+}
+
+// parseResult 解析结果保留策略, ttl保留到有效期结束, once第一次读取后删除, keep保留到被删除.
+func parseResult(b []byte) (uint8, bool) {
+	switch string(b) {
+	case "ttl":
+		return queue.ResultTTL, true
+	case "once":
+		return queue.ResultOnce, true
+	case "keep":
+		return queue.ResultKeep, true
+	}
+
+	return 0, false
+}
+
+// resultLifespan 根据结果保留策略获取结果有效期, 0不过期.
+func resultLifespan(result uint8, ttl time.Duration) time.Duration {
+	if result == queue.ResultKeep {
+		return 0
+	}
+
+	return ttl
+}
+
+// QueueERR 根据队列操作结果返回.
+func QueueERR(conn link.Connect, err error) {
+	switch err {
+	case nil:
+		conn.WriteString("1", "成功")
+	case queue.ErrNotFound:
+		conn.WriteString("404", "不存在")
+	case queue.ErrNotReserved:
+		conn.WriteString("403", "任务不是当前连接预订")
+	case queue.ErrFinished:
+		conn.WriteString("409", "任务已经完成")
+	default:
+		SystemERR(conn, err)
+		logf(err)
+	}
+}
+
+// Snapshot 生成快照并压缩日志, 需要管理员权限.
+func Snapshot(conn link.Connect, _ [][]byte) {
+	if Owner(conn) != queue.Admin {
+		conn.WriteString("403", "需要管理员权限")
+		return
+	}
+	if DefaultJournal == nil {
+		conn.WriteString("0", "没有开启持久化")
+		return
+	}
+
+	err := TakeSnapshot()
+	if err != nil {
+		SystemERR(conn, err)
+		logf(err)
+	} else {
+		conn.WriteString("1", "成功")
+	}
+}
+
+// TakeSnapshot 将队列与缓存数据写入快照, 并压缩日志.
+func TakeSnapshot() error {
+
+	return DefaultJournal.Snapshot(func(w journal.Journal) error {
+		if err := DefaultQueue.Snapshot(w); err != nil {
+			return err
+		}
+
+		return DefaultCache.Snapshot(w)
+	})
+}
+
+// StartSnapshot 定时生成快照.
+func StartSnapshot(dur time.Duration) {
+	tick := time.Tick(dur)
+	for {
+		<-tick
+		logf(TakeSnapshot())
+	}
+}
+
+// Replay 重放一条日志记录.
+func Replay(rec [][]byte) error {
+	if len(rec) < 1 {
+		return nil
+	}
+
+	switch string(rec[0]) {
+	case "Set", "Delete", "Clear":
+		return DefaultCache.Replay(rec)
+	}
+
+	return DefaultQueue.Replay(rec)
+}
+
 // EOF 连接断开回调函数.
 func EOF(conn interface{}) {
 	err := DefaultQueue.RestoreAll(conn)
@@ -0,0 +1,71 @@
+package queue
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// benchTubes 并发测试使用的队列数.
+const benchTubes = 8
+
+// benchKey 生成不重复的任务key.
+func benchKey(seq *uint64) string {
+
+	return "job-" + strconv.FormatUint(atomic.AddUint64(seq, 1), 10)
+}
+
+// benchTube 按key选择队列.
+func benchTube(n uint64) string {
+
+	return "tube-" + strconv.FormatUint(n%benchTubes, 10)
+}
+
+// BenchmarkJoin 并发添加任务(AddJob).
+func BenchmarkJoin(b *testing.B) {
+	Q := NewQueue(time.Minute, nil)
+	value := []byte("value")
+	var seq uint64
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n := atomic.AddUint64(&seq, 1)
+			Q.Join(benchTube(n), "job-"+strconv.FormatUint(n, 10), value, 0, 0, 1024, ResultTTL)
+		}
+	})
+}
+
+// BenchmarkJoinReserve 并发添加并获取任务(AddJob + GetJob).
+func BenchmarkJoinReserve(b *testing.B) {
+	Q := NewQueue(time.Minute, nil)
+	value := []byte("value")
+	var seq, worker uint64
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		conn := &struct{ id uint64 }{atomic.AddUint64(&worker, 1)}
+		tube := benchTube(conn.id)
+		for pb.Next() {
+			Q.Join(tube, benchKey(&seq), value, 0, 0, 1024, ResultTTL)
+			Q.GetAndDoing(tube, conn)
+		}
+	})
+}
+
+// BenchmarkLifecycle 并发执行完整的任务流程(AddJob + GetJob + SetReturn完成任务).
+func BenchmarkLifecycle(b *testing.B) {
+	Q := NewQueue(time.Minute, nil)
+	value := []byte("value")
+	var seq, worker uint64
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		conn := &struct{ id uint64 }{atomic.AddUint64(&worker, 1)}
+		tube := benchTube(conn.id)
+		for pb.Next() {
+			Q.Join(tube, benchKey(&seq), value, 0, 0, 1024, ResultTTL)
+			if key, _, ok := Q.GetAndDoing(tube, conn); ok {
+				Q.Finish(key, conn, nil)
+			}
+		}
+	})
+}
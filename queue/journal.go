@@ -0,0 +1,286 @@
+package queue
+
+import (
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Journal 任务状态变化的追加写日志.
+type Journal interface {
+	// Append 追加一条记录.
+	Append(args ...[]byte) error
+}
+
+// SetJournal 设置日志, 任务状态变化都会写入日志.
+func (Q *queue) SetJournal(j Journal) {
+	Q.Lock()
+	defer Q.Unlock()
+
+	Q.journal = j
+}
+
+// record 记录任务状态变化, 返回日志写入错误, 调用方需要持有任务分片锁.
+func (Q *queue) record(op string, itm *job) error {
+	Q.RLock()
+	j := Q.journal
+	Q.RUnlock()
+	if j == nil {
+		return nil
+	}
+
+	var value []byte
+	if op == "Join" {
+		value, _ = Q.engine.Get(itm.key)
+	}
+
+	return j.Append(itm.record(op, value)...)
+}
+
+// record 任务日志记录.
+// 格式: op key tube status attempts priority ttr readyAt createTime reason [value result].
+func (itm *job) record(op string, value []byte) [][]byte {
+	args := [][]byte{
+		[]byte(op),
+		[]byte(itm.key),
+		[]byte(itm.tube),
+		formatInt(int64(itm.status)),
+		formatInt(int64(itm.attempts)),
+		formatInt(int64(itm.priority)),
+		formatInt(int64(itm.ttr)),
+		formatTime(itm.readyAt),
+		formatTime(itm.createTime),
+		[]byte(itm.reason),
+	}
+	if op == "Join" {
+		args = append(args, value, formatInt(int64(itm.result)))
+	}
+
+	return args
+}
+
+// recordTube 记录队列配置变化, 返回日志写入错误.
+func (Q *queue) recordTube(tube, name string, val int64) error {
+	Q.RLock()
+	j := Q.journal
+	Q.RUnlock()
+	if j == nil {
+		return nil
+	}
+
+	return j.Append([]byte("TubeSet"), []byte(tube), []byte(name), formatInt(val))
+}
+
+// Replay 重放一条日志记录, 只恢复任务数据, 重放完成后调用Recover重建队列.
+func (Q *queue) Replay(rec [][]byte) error {
+	if len(rec) < 1 {
+		return ErrJournal
+	}
+
+	if string(rec[0]) == "TubeSet" {
+		if len(rec) < 4 {
+			return ErrJournal
+		}
+		val, err := strconv.ParseInt(string(rec[3]), 10, 64)
+		if err != nil {
+			return ErrJournal
+		}
+		Q.withTube(string(rec[1]), func(tubes *li) {
+			switch string(rec[2]) {
+			case "ttr":
+				tubes.ttr = time.Duration(val)
+			case "attempts":
+				tubes.attempts = int(val)
+			}
+		})
+
+		return nil
+	}
+
+	if len(rec) < 10 {
+		return ErrJournal
+	}
+	var nums [6]int64
+	for k := range nums {
+		n, err := strconv.ParseInt(string(rec[k+3]), 10, 64)
+		if err != nil {
+			return ErrJournal
+		}
+		nums[k] = n
+	}
+
+	key := string(rec[1])
+	s := Q.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	itm := s.jobs[key]
+	if itm == nil {
+		if string(rec[0]) != "Join" || len(rec) < 11 {
+			// 任务已经被回收.
+			return nil
+		}
+		var result uint64
+		if len(rec) > 11 {
+			n, err := strconv.ParseUint(string(rec[11]), 10, 8)
+			if err != nil {
+				return ErrJournal
+			}
+			result = n
+		}
+		if err := Q.engine.Set(key, rec[10]); err != nil {
+			return err
+		}
+		itm = &job{
+			key:    key,
+			result: uint8(result),
+		}
+		s.jobs[key] = itm
+	}
+	itm.tube = string(rec[2])
+	itm.status = uint8(nums[0])
+	itm.attempts = int(nums[1])
+	itm.priority = uint32(nums[2])
+	itm.ttr = time.Duration(nums[3])
+	itm.readyAt = parseTime(nums[4])
+	itm.createTime = parseTime(nums[5])
+	itm.reason = string(rec[9])
+	itm.seq = atomic.AddUint64(&Q.seq, 1)
+
+	return nil
+}
+
+// Recover 日志重放完成后重建队列, 正在进行中的任务重新放回队列, 删除没有任务信息的存储数据.
+func (Q *queue) Recover() error {
+	// 按顺序锁定所有分片, 其他操作只会锁定一个分片.
+	for _, s := range Q.db {
+		s.Lock()
+		defer s.Unlock()
+	}
+
+	err := Q.engine.Range(func(key string) bool {
+		if Q.getJob(key) == nil {
+			Q.engine.Delete(key)
+		}
+
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	jobs := make([]*job, 0)
+	for _, s := range Q.db {
+		for _, itm := range s.jobs {
+			switch itm.status {
+			case RESERVED:
+				// 服务重启, 连接已经断开.
+				itm.status = READY
+				jobs = append(jobs, itm)
+			case READY, BURIED:
+				jobs = append(jobs, itm)
+			case WAITING:
+				Q.getTube(itm.tube)
+				Q.timer.Add(itm.key, timerDelay, itm.readyAt)
+			case DELAYED, FAILED:
+				Q.timer.Add(itm.key, timerExpire, time.Now().Add(Q.dur))
+			}
+		}
+	}
+
+	sort.Sort(bySeq(jobs))
+	for _, itm := range jobs {
+		if itm.status == BURIED {
+			Q.withTube(itm.tube, func(tubes *li) {
+				tubes.buried.Put(itm.key)
+			})
+		} else {
+			Q.ready(itm)
+		}
+	}
+
+	return nil
+}
+
+// bySeq 按状态变化顺序排序.
+type bySeq []*job
+
+func (s bySeq) Len() int           { return len(s) }
+func (s bySeq) Less(i, j int) bool { return s[i].seq < s[j].seq }
+func (s bySeq) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// formatInt 格式化数字.
+func formatInt(n int64) []byte {
+
+	return []byte(strconv.FormatInt(n, 10))
+}
+
+// formatTime 格式化时间, 零值为0.
+func formatTime(t time.Time) []byte {
+	if t.IsZero() {
+		return []byte("0")
+	}
+
+	return formatInt(t.UnixNano())
+}
+
+// parseTime 解析时间, 0为零值.
+func parseTime(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, n)
+}
+
+// Snapshot 将当前队列配置与未完成的任务写入快照, 已经完成的任务不写入.
+func (Q *queue) Snapshot(w Journal) error {
+	Q.RLock()
+	tubes := make(map[string][2]int64, len(Q.tube))
+	for name, tube := range Q.tube {
+		tube.Lock()
+		tubes[name] = [2]int64{int64(tube.ttr), int64(tube.attempts)}
+		tube.Unlock()
+	}
+	Q.RUnlock()
+
+	jobs := make([]*job, 0)
+	for _, s := range Q.db {
+		s.RLock()
+		for _, itm := range s.jobs {
+			if itm.status != DELAYED && itm.status != FAILED {
+				cp := *itm
+				jobs = append(jobs, &cp)
+			}
+		}
+		s.RUnlock()
+	}
+
+	for name, conf := range tubes {
+		if conf[0] > 0 {
+			if err := w.Append([]byte("TubeSet"), []byte(name), []byte("ttr"), formatInt(conf[0])); err != nil {
+				return err
+			}
+		}
+		if conf[1] > 0 {
+			if err := w.Append([]byte("TubeSet"), []byte(name), []byte("attempts"), formatInt(conf[1])); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 按进入队列的顺序写入, 重放后保持先进先出.
+	sort.Sort(bySeq(jobs))
+	for _, itm := range jobs {
+		value, ok := Q.engine.Get(itm.key)
+		if !ok {
+			continue
+		}
+		if err := w.Append(itm.record("Join", value)...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// errDisk 测试使用的日志写入错误.
+var errDisk = errors.New("disk full")
+
+// records 保存在内存中的日志, err不为空时写入失败.
+type records struct {
+	sync.Mutex
+	recs [][][]byte
+	err  error
+}
+
+// Append 追加一条记录.
+func (r *records) Append(args ...[]byte) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.err != nil {
+
+		return r.err
+	}
+	r.recs = append(r.recs, args)
+
+	return nil
+}
+
+// fail 设置日志是否写入失败.
+func (r *records) fail(broken bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.err = nil
+	if broken {
+		r.err = errDisk
+	}
+}
+
+// TestJoinJournalError 日志写入失败时添加任务返回错误, 任务与数据都不保留.
+func TestJoinJournalError(t *testing.T) {
+	Q := NewQueue(time.Minute, nil).(*queue)
+	Q.SetJournal(&records{err: errDisk})
+
+	if err := Q.Join("stock", "job", []byte("value"), 0, 0, 1024, ResultTTL); err != errDisk {
+		t.Fatalf("Join err = %v, want %v", err, errDisk)
+	}
+	if _, ok := Q.Info("job"); ok {
+		t.Fatal("job exists after journal error")
+	}
+	if _, ok := Q.engine.Get("job"); ok {
+		t.Fatal("job value exists after journal error")
+	}
+	if _, _, ok := Q.GetAndDoing("stock", &struct{}{}); ok {
+		t.Fatal("job reserved after journal error")
+	}
+}
+
+// TestJournalError 日志写入失败时修改任务状态与队列配置返回错误.
+func TestJournalError(t *testing.T) {
+	Q := NewQueue(time.Minute, nil).(*queue)
+	j := &records{}
+	Q.SetJournal(j)
+	conn := &struct{}{}
+	for _, key := range []string{"finish", "release", "fail", "bury"} {
+		Q.Join("stock", key, []byte("value"), 0, 0, 1024, ResultTTL)
+		if _, _, ok := Q.GetAndDoing("stock", conn); !ok {
+			t.Fatal("reserve failed")
+		}
+	}
+	j.fail(true)
+
+	for name, err := range map[string]error{
+		"Finish":         Q.Finish("finish", conn, nil),
+		"Release":        Q.Release("release", conn, 0),
+		"Fail":           Q.Fail("fail", conn, "reason"),
+		"Bury":           Q.Bury("bury", conn, "reason"),
+		"SetTTR":         Q.SetTTR("stock", time.Second),
+		"SetMaxAttempts": Q.SetMaxAttempts("stock", 3),
+	} {
+		if err != errDisk {
+			t.Errorf("%s err = %v, want %v", name, err, errDisk)
+		}
+	}
+	if n, err := Q.Kick("stock", 1); n != 1 || err != errDisk {
+		t.Errorf("Kick = %d, %v, want 1, %v", n, err, errDisk)
+	}
+}
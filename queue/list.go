@@ -1,146 +1,301 @@
 package queue
 
 import (
+	"container/list"
 	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"../h32"
+	"../store"
+	"../timer"
 )
 
 // queue 队列结构体.
+// 加锁顺序: 任务分片锁 -> 队列表锁 -> 单个队列锁 -> 预订记录锁, 不能反向加锁.
 type queue struct {
-	sync.RWMutex                                // 读写锁.
-	tube map[string]*li                         // 队列排队.
-	log  map[interface{}]map[string]interface{} // 记录单个任务doing状态的key.
-	db   []map[string]*job                      // 原始数据.
-	dur  time.Duration                          // 垃圾回收周期.
+	seq          uint64                                 // 任务状态变化序号, 原子操作.
+	sync.RWMutex                                        // 队列表锁, 保护tube、onFail、journal.
+	tube         map[string]*li                         // 队列排队.
+	logMu        sync.Mutex                             // 预订记录锁.
+	log          map[interface{}]map[string]interface{} // 记录单个任务doing状态的key.
+	db           []*shard                               // 原始数据.
+	dur          time.Duration                          // 已完成任务的保留时间, 也是空队列的检查周期.
+	timer        *timer.Timer                           // 定时器.
+	onFail       func(key, reason string, result uint8) // 任务失败或进入死信队列时回调.
+	journal      Journal                                // 任务状态变化日志.
+	engine       store.Engine                           // 任务数据存储引擎.
+}
+
+// shard 任务数据分片, 每个分片有自己的锁.
+type shard struct {
+	sync.RWMutex                 // 读写锁.
+	jobs         map[string]*job // 任务.
 }
 
 // job 任务信息.
 type job struct {
-	tube   string // 消息队列名称.
-	key    string // 唯一ID标示.
-	value  []byte // 数据.
-	status uint8  // 任务状态.
+	tube       string        // 消息队列名称.
+	key        string        // 唯一ID标示.
+	status     uint8         // 任务状态.
+	ttr        time.Duration // 任务执行时间限制, 0使用队列配置.
+	deadline   time.Time     // 预订到期时间.
+	readyAt    time.Time     // 延迟任务可执行时间.
+	priority   uint32        // 优先级, 数字越小越优先.
+	attempts   int           // 已被获取执行的次数.
+	reason     string        // 最后一次失败原因.
+	result     uint8         // 结果保留策略.
+	createTime time.Time     // 创建时间.
+	conn       interface{}   // 预订任务的连接.
+	seq        uint64        // 最后一次进入队列的序号.
+	pending    bool          // 已经直接交给等待的连接, 连接还没有收到.
 }
 
 // li 任务连.
+// 链表中的key可能已经失效, 取出后需要在任务分片锁内判定任务状态.
 type li struct {
-	list       Listed                           // 链表.
-	channels   map[chan interface{}]interface{} // 消息订阅.
-	updateTime time.Time                        // 更新时间.
+	sync.Mutex                // 锁.
+	list       PriorityListed // 链表.
+	buried     Listed         // 被埋葬的任务.
+	waiters    *list.List     // 等待任务的连接, 先进先出, 每个新任务只交给或者通知一个连接.
+	updateTime time.Time      // 更新时间.
+	ttr        time.Duration  // 队列任务执行时间限制, 0不限制.
+	attempts   int            // 任务最大执行次数, 0不限制.
+	removed    bool           // 已经从队列表中清除.
 }
 
-// Join 向队列中，添加一个任务.
-func (Q *queue) Join(tube, key string, value []byte) error {
+// Join 向队列中，添加一个任务, delay大于0时任务延迟到期后才能被获取.
+// 优先级数字越小越先被获取.
+func (Q *queue) Join(tube, key string, value []byte, ttr, delay time.Duration, pri uint32, result uint8) error {
 	_, ok := Q.GetDb(key)
 	if ok {
 
 		return nil
 	}
-	Q.Lock()
-	defer Q.Unlock()
+	if err := Q.engine.Set(key, value); err != nil {
+
+		return err
+	}
+
+	s := Q.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.jobs[key]; ok {
 
-	off := h32.DefaultHash.GetOffset(key, BlockSize, BucketSize)
-	bucket := Q.db[off]
-	if bucket == nil {
-		bucket = make(map[string]*job, 1)
-		Q.db[off] = bucket
+		return nil
+	}
+	itm := &job{
+		tube:       tube,
+		key:        key,
+		status:     READY,
+		ttr:        ttr,
+		priority:   pri,
+		result:     result,
+		createTime: time.Now(),
 	}
-	bucket[key] = &job{
-		tube:   tube,
-		key:    key,
-		value:  value,
-		status: READY,
+	if delay > 0 {
+		itm.status = WAITING
+		itm.readyAt = time.Now().Add(delay)
 	}
+	// 日志写入成功后才加入队列, 写入失败的任务不会被获取.
+	if err := Q.record("Join", itm); err != nil {
+		Q.engine.Delete(key)
 
-	tubes, ok := Q.tube[tube]
-	if !ok {
-		tubes = &li{
-			list:     NewListed(),
-			channels: make(map[chan interface{}]interface{}, 1),
-		}
-		Q.tube[tube] = tubes
+		return err
+	}
+	s.jobs[key] = itm
+	if delay > 0 {
+		Q.withTube(tube, func(tubes *li) {
+			tubes.updateTime = time.Now()
+		})
+		Q.timer.Add(key, timerDelay, itm.readyAt)
 	} else {
-		for channel := range tubes.channels {
-			channel <- nil
-		}
-		tubes.channels = make(map[chan interface{}]interface{}, 1)
+		Q.ready(itm)
 	}
-	tubes.list.Put(key)
-	tubes.updateTime = time.Now()
 
 	return nil
 }
 
-// Finish 完成一个任务.
-func (Q *queue) Finish(key string, conn interface{}) bool {
-	Q.Lock()
-	defer Q.Unlock()
+// Finish 完成一个任务, 任务必须由当前连接预订.
+// store不为nil时在检查预订之后、完成之前保存结果, 持有任务分片锁, 预订过期的连接不会覆盖结果; 保存失败时任务保持预订.
+func (Q *queue) Finish(key string, conn interface{}, store func() error) error {
+	s := Q.shard(key)
+	s.Lock()
+	defer s.Unlock()
 
-	off := h32.DefaultHash.GetOffset(key, BlockSize, BucketSize)
-	if bucket := Q.db[off]; bucket != nil {
-		if itm, ok := bucket[key]; ok {
-			itm.status = DELAYED
-			if logs, ok := Q.log[conn]; ok {
-				delete(logs, key)
-			}
+	itm, err := Q.reserved(key, conn)
+	if err != nil {
 
-			// 删除log中的数据.
-			if m, ok := Q.log[conn]; ok {
-				delete(m, key)
-			}
-			return true
+		return err
+	}
+	if store != nil {
+		if err = store(); err != nil {
+
+			return err
 		}
 	}
 
-	return false
+	// 删除log中的数据.
+	Q.unreserve(itm)
+	itm.status = DELAYED
+	Q.timer.Add(key, timerExpire, time.Now().Add(Q.dur))
+
+	return Q.record("Finish", itm)
 }
 
 // GetAndDoing 获取一个任务，修改任务状态为正在开始中.
 func (Q *queue) GetAndDoing(tube string, conn interface{}) (string, []byte, bool) {
-	Q.Lock()
-	defer Q.Unlock()
+	tubes := Q.findTube(tube)
+	if tubes == nil {
+
+		return "", nil, false
+	}
 
-	if tubes, ok := Q.tube[tube]; ok {
-		GO:
-		if key, ok := tubes.list.Out(); ok {
-			off := h32.DefaultHash.GetOffset(key, BlockSize, BucketSize)
-			if bucket := Q.db[off]; bucket != nil {
-				if itm, ok := bucket[key]; ok {
-					if itm.status == READY {
-						itm.status = RESERVED
-						logs, ok := Q.log[conn]
-						if !ok {
-							logs = make(map[string]interface{}, 1)
-							Q.log[conn] = logs
-						}
-						logs[key] = nil
-
-						return key, itm.value, true
-					}
-					goto GO
+	for {
+		tubes.Lock()
+		key, ok := tubes.list.Out()
+		tubes.Unlock()
+		if !ok {
+
+			return "", nil, false
+		}
+		if Q.reserve(key, conn) {
+			value, _ := Q.engine.Get(key)
+
+			return key, value, true
+		}
+	}
+}
+
+// ReserveWait 获取一个任务并预订, 队列中没有任务时等待, 超时返回timeout错误.
+// 等待的连接按先后顺序被唤醒, 每个新任务只唤醒一个连接.
+func (Q *queue) ReserveWait(tube string, conn interface{}, timeout time.Duration, ch chan interface{}) (string, []byte, error) {
+	_, key, value, err := Q.ReserveAny([]string{tube}, conn, timeout, ch)
+
+	return key, value, err
+}
+
+// ReserveAny 按顺序从多个队列中获取一个任务并预订, 返回任务所在的队列, 所有队列都没有任务时等待, 超时返回timeout错误.
+// 等待时新放入队列的任务直接交给最早等待的连接.
+func (Q *queue) ReserveAny(tubes []string, conn interface{}, timeout time.Duration, ch chan interface{}) (string, string, []byte, error) {
+	tick := time.NewTimer(timeout)
+	defer tick.Stop()
+	for {
+		for _, tube := range tubes {
+			if key, value, ok := Q.GetAndDoing(tube, conn); ok {
+				if closed(ch) {
+					// 连接已经断开, 断开回调可能已经执行, 任务放回队列.
+					Q.giveBack(key, conn)
+
+					return "", "", nil, errors.New("EOF")
 				}
+
+				return tube, key, value, nil
+			}
+		}
+
+		w := newWaiter(conn)
+		elems := Q.wait(tubes, w)
+		var err error
+		// 加入等待队列之前放入队列的任务不会交给等待者, 重新获取.
+		if !Q.existsAny(tubes) {
+			select {
+			case <-w.c:
+			case <-ch:
+				err = errors.New("EOF")
+			case <-tick.C:
+				err = errors.New("timeout")
+			}
+		}
+		Q.unwait(tubes, elems)
+
+		tube, key, woken := w.cancel()
+		if key != "" {
+			if err != nil && err.Error() == "EOF" || closed(ch) {
+				// 连接已经断开, 任务放回队列.
+				Q.giveBack(key, conn)
+
+				return "", "", nil, errors.New("EOF")
 			}
+			Q.delivered(key)
+			value, _ := Q.engine.Get(key)
+
+			return tube, key, value, nil
+		}
+		if err != nil {
+			if woken {
+				// 被唤醒但没有获取任务, 唤醒其他等待者.
+				Q.wakeAny(tubes)
+			}
+
+			return "", "", nil, err
 		}
 	}
+}
+
+// closed 判定连接是否已经断开, 连接断开时通知通道被关闭.
+func closed(ch chan interface{}) bool {
+	select {
+	case <-ch:
+
+		return true
+	default:
 
-	return "", nil, false
+		return false
+	}
+}
+
+// reserve 预订一个等待执行的任务, 任务已经失效返回false.
+func (Q *queue) reserve(key string, conn interface{}) bool {
+	s := Q.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	itm, ok := s.jobs[key]
+	if !ok || itm.status != READY {
+
+		return false
+	}
+	Q.reserveJob(itm, conn)
+
+	return true
+}
+
+// reserveJob 修改任务状态为正在执行, 调用方需要持有任务分片锁.
+func (Q *queue) reserveJob(itm *job, conn interface{}) {
+	key := itm.key
+	itm.status = RESERVED
+	itm.conn = conn
+	itm.attempts++
+	itm.pending = false
+	Q.logMu.Lock()
+	logs, ok := Q.log[conn]
+	if !ok {
+		logs = make(map[string]interface{}, 1)
+		Q.log[conn] = logs
+	}
+	logs[key] = nil
+	Q.logMu.Unlock()
+	if ttr := Q.jobTTR(itm); ttr > 0 {
+		itm.deadline = time.Now().Add(ttr)
+		Q.timer.Add(key, timerTTR, itm.deadline)
+	}
+	// 预订状态重启后会恢复为等待执行, 日志写入失败不影响恢复.
+	Q.record("Reserve", itm)
 }
 
 // Exists 判定一个人是否存在, 该任务必须为未开始，正在完成中.
 func (Q *queue) Exists(key string) bool {
-	Q.Lock()
-	defer Q.Unlock()
+	s := Q.shard(key)
+	s.RLock()
+	defer s.RUnlock()
 
-	off := h32.DefaultHash.GetOffset(key, BlockSize, BucketSize)
-	if bucket := Q.db[off]; bucket != nil {
-		if itm, ok := bucket[key]; ok {
-			if itm.status == READY || itm.status == RESERVED {
+	if itm, ok := s.jobs[key]; ok {
+		if itm.status == READY || itm.status == RESERVED || itm.status == WAITING || itm.status == BURIED {
 
-				return true
-			}
+			return true
 		}
 	}
 
@@ -154,23 +309,20 @@ func (Q *queue) StartAndGC() error {
 	return nil
 }
 
-// vaccuum 周期性检查数据.
+// vaccuum 周期性检查空队列, 已完成的任务由定时器到期删除.
 func (Q *queue) vaccuum() {
 	tick := time.Tick(Q.dur)
 
 	for {
 		<-tick
-		if Q.db == nil {
-			continue
-		}
-
-		for _, jobs := range Q.db {
-			for key := range jobs {
-				Q.itemExpired(key)
-			}
+		Q.RLock()
+		names := make([]string, 0, len(Q.tube))
+		for name := range Q.tube {
+			names = append(names, name)
 		}
+		Q.RUnlock()
 
-		for name := range Q.tube {
+		for _, name := range names {
 			Q.itemExpiredQueue(name)
 		}
 	}
@@ -182,7 +334,21 @@ func (Q *queue) itemExpiredQueue(queue string) error {
 	defer Q.Unlock()
 
 	if list, ok := Q.tube[queue]; ok {
+		list.Lock()
+		defer list.Unlock()
+
+		// 存在等待执行、被埋葬的任务或者订阅者不能清除.
+		if list.list.Length() > 0 || list.buried.Length() > 0 || list.waiters.Len() > 0 {
+
+			return nil
+		}
+		// 设置了队列配置不能清除, 否则配置会丢失.
+		if list.configured() {
+
+			return nil
+		}
 		if time.Now().Sub(list.updateTime) > time.Hour * 24 {
+			list.removed = true
 			delete(Q.tube, queue)
 		}
 	}
@@ -190,159 +356,626 @@ func (Q *queue) itemExpiredQueue(queue string) error {
 	return nil
 }
 
-// itemExpired 判定队列是否及完成，如果已经已经完成，则删除.
-func (Q *queue) itemExpired(key string) {
-	Q.Lock()
-	defer Q.Unlock()
+// RestoreOne 还原一个任务, 任务必须由conn预订.
+func (Q *queue) RestoreOne(key string, conn interface{}) bool {
+	s := Q.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	if itm, ok := s.jobs[key]; ok {
+		if itm.status == RESERVED && itm.conn == conn {
+			Q.unreserve(itm)
+			if itm.pending {
+				// 连接没有收到任务, 不计入执行次数.
+				Q.putBack(itm)
+			} else {
+				Q.retry(itm, "连接断开")
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// Usr1 添加一个通知，如果有数据，通知用户.
+// 多个连接等待时, 每个新任务只通知最早等待的一个连接.
+func (Q *queue) Usr1(tube string, ch chan interface{}) (ok bool, err error) {
+	ok, err = Q.existsQueue(tube)
+	if err != nil || ok {
+
+		return
+	}
+
+	tubes := []string{tube}
+	w := newWaiter(nil)
+	elems := Q.wait(tubes, w)
+	ok, err = Q.existsQueue(tube)
+	if err == nil && !ok {
+		select {
+		case <-w.c:
+			ok, err = Q.existsQueue(tube)
+		case <-ch:
+			err = errors.New("EOF")
+		}
+	}
+	Q.unwait(tubes, elems)
+	if _, _, woken := w.cancel(); woken && !ok {
+		// 被唤醒但没有通知到连接, 唤醒其他等待者.
+		Q.wakeAny(tubes)
+	}
+
+	return ok, err
+}
+
+// existsQueue 判定指定消息队列中，是否存在队列.
+func (Q *queue) existsQueue(tube string) (bool, error) {
+	if tubes := Q.findTube(tube); tubes != nil {
+		tubes.Lock()
+		defer tubes.Unlock()
 
-	off := h32.DefaultHash.GetOffset(key, BlockSize, BucketSize)
-	if bucket := Q.db[off]; bucket != nil {
-		if job, ok := bucket[key]; ok {
-			if job.status == DELAYED {
-				delete(bucket, key)
+		if tubes.list.Length() > 0 {
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// GetDb 获取DB数据.
+func (Q *queue) GetDb(key string) ([]byte, bool) {
+	s := Q.shard(key)
+	s.RLock()
+	defer s.RUnlock()
+
+	if itm := Q.getJob(key); itm != nil {
+
+		return Q.engine.Get(key)
+	}
+
+	return nil, false
+}
+
+// RestoreAll 还原一个连接对象正在做的任务进行还原.
+func (Q *queue) RestoreAll(conn interface{}) error {
+	Q.logMu.Lock()
+	logs := Q.log[conn]
+	keys := make([]string, 0, len(logs))
+	for key := range logs {
+		keys = append(keys, key)
+	}
+	Q.logMu.Unlock()
+
+	for _, key := range keys {
+		Q.RestoreOne(key, conn)
+	}
+
+	Q.logMu.Lock()
+	delete(Q.log, conn)
+	Q.logMu.Unlock()
+
+	return nil
+}
+
+// Touch 延长一个正在进行中任务的执行时间.
+func (Q *queue) Touch(key string, conn interface{}) bool {
+	s := Q.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	if itm, ok := s.jobs[key]; ok {
+		if itm.status == RESERVED && itm.conn == conn {
+			if ttr := Q.jobTTR(itm); ttr > 0 {
+				itm.deadline = time.Now().Add(ttr)
+				Q.timer.Add(key, timerTTR, itm.deadline)
 			}
+
+			return true
 		}
 	}
+
+	return false
 }
 
-// RestoreOne 还原一个任务.
-func (Q *queue) RestoreOne(key string, conn interface{}) bool {
+// SetTTR 设置队列任务执行时间限制.
+func (Q *queue) SetTTR(tube string, ttr time.Duration) error {
+	Q.withTube(tube, func(tubes *li) {
+		tubes.ttr = ttr
+	})
+
+	return Q.recordTube(tube, "ttr", int64(ttr))
+}
+
+// SetMaxAttempts 设置队列任务最大执行次数.
+func (Q *queue) SetMaxAttempts(tube string, n int) error {
+	Q.withTube(tube, func(tubes *li) {
+		tubes.attempts = n
+	})
+
+	return Q.recordTube(tube, "attempts", int64(n))
+}
+
+// SetFailHandler 设置任务失败或进入死信队列时的回调函数.
+func (Q *queue) SetFailHandler(f func(key, reason string, result uint8)) {
 	Q.Lock()
 	defer Q.Unlock()
 
-	off := h32.DefaultHash.GetOffset(key, BlockSize, BucketSize)
-	if bucket := Q.db[off]; bucket != nil {
-		if itm, ok := bucket[key]; ok {
-			if itm.status == RESERVED {
-				itm.status = READY
-				tubes, ok := Q.tube[itm.tube]
-				if !ok {
-					tubes = &li{
-						list:     NewListed(),
-						channels: make(map[chan interface{}]interface{}, 1),
-					}
-					Q.tube[itm.tube] = tubes
-				} else {
-					for channel := range tubes.channels {
-						channel <- nil
-					}
-					tubes.channels = make(map[chan interface{}]interface{}, 1)
-				}
-				tubes.list.Put(key)
-				if logs, ok := Q.log[conn]; ok {
-					delete(logs, key)
-				}
+	Q.onFail = f
+}
 
-				return true
-			}
+// Release 将当前连接预订的任务放回队列, delay大于0时延迟放回.
+func (Q *queue) Release(key string, conn interface{}, delay time.Duration) error {
+	s := Q.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	itm, err := Q.reserved(key, conn)
+	if err != nil {
+
+		return err
+	}
+
+	Q.unreserve(itm)
+	if delay > 0 {
+		itm.status = WAITING
+		itm.readyAt = time.Now().Add(delay)
+		Q.timer.Add(key, timerDelay, itm.readyAt)
+	} else {
+		Q.ready(itm)
+	}
+
+	return Q.record("Release", itm)
+}
+
+// Fail 将当前连接预订的任务标记为失败.
+func (Q *queue) Fail(key string, conn interface{}, reason string) error {
+	s := Q.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	itm, err := Q.reserved(key, conn)
+	if err != nil {
+
+		return err
+	}
+
+	Q.unreserve(itm)
+	itm.status = FAILED
+	itm.reason = reason
+	Q.timer.Add(key, timerExpire, time.Now().Add(Q.dur))
+	err = Q.record("Fail", itm)
+	Q.fail(itm)
+
+	return err
+}
+
+// Bury 将当前连接预订的任务埋葬, 埋葬的任务不会被获取和回收, 需要Kick放回队列.
+func (Q *queue) Bury(key string, conn interface{}, reason string) error {
+	s := Q.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	itm, err := Q.reserved(key, conn)
+	if err != nil {
+
+		return err
+	}
+
+	Q.unreserve(itm)
+	itm.status = BURIED
+	itm.reason = reason
+	itm.seq = atomic.AddUint64(&Q.seq, 1)
+	Q.withTube(itm.tube, func(tubes *li) {
+		tubes.buried.Put(key)
+		tubes.updateTime = time.Now()
+	})
+
+	return Q.record("Bury", itm)
+}
+
+// Kick 将队列中最多n个被埋葬的任务放回队列, 返回放回的任务数.
+func (Q *queue) Kick(tube string, n int) (int, error) {
+	tubes := Q.findTube(tube)
+	if tubes == nil {
+
+		return 0, nil
+	}
+
+	var count int
+	for count < n {
+		tubes.Lock()
+		key, ok := tubes.buried.Out()
+		tubes.Unlock()
+		if !ok {
+			break
+		}
+		ok, err := Q.kick(key)
+		if ok {
+			count++
+		}
+		if err != nil {
+
+			return count, err
 		}
 	}
 
-	return false
+	return count, nil
 }
 
-// Usr1 添加一个通知，如果有数据，通知用户.
-func (Q *queue) Usr1(tube string, ch chan interface{}) (ok bool, err error) {
-	ok, err = Q.existsQueue(tube)
-	if err != nil || ok {
+// kick 将一个被埋葬的任务放回队列, 任务已经失效返回false.
+func (Q *queue) kick(key string) (bool, error) {
+	s := Q.shard(key)
+	s.Lock()
+	defer s.Unlock()
 
-		return
+	if itm, ok := s.jobs[key]; ok && itm.status == BURIED {
+		Q.ready(itm)
+
+		return true, Q.record("Kick", itm)
 	}
 
-	c := Q.registerMessage(tube)
-	ok, err = Q.existsQueue(tube)
-	if err != nil || ok {
-		Q.deregisterMessage(tube, c)
+	return false, nil
+}
 
-		return
+// reserved 获取当前连接预订的任务, Admin可以获取任何连接预订的任务, 调用方需要持有任务分片锁.
+func (Q *queue) reserved(key string, conn interface{}) (*job, error) {
+	itm := Q.getJob(key)
+	if itm == nil {
+
+		return nil, ErrNotFound
 	}
+	if itm.status == DELAYED || itm.status == FAILED {
 
-	select {
-	case <-c:
-		ok, err = Q.existsQueue(tube)
-	case <-ch:
-		err = errors.New("EOF")
+		return nil, ErrFinished
 	}
-	Q.deregisterMessage(tube, c)
+	if itm.status != RESERVED {
 
-	return ok, err
+		return nil, ErrNotReserved
+	}
+	if conn == Admin {
+
+		return itm, nil
+	}
+
+	Q.logMu.Lock()
+	defer Q.logMu.Unlock()
+
+	if logs, ok := Q.log[conn]; !ok {
+
+		return nil, ErrNotReserved
+	} else if _, ok := logs[key]; !ok {
+
+		return nil, ErrNotReserved
+	}
+
+	return itm, nil
+}
+
+// unreserve 取消任务预订, 调用方需要持有任务分片锁.
+func (Q *queue) unreserve(itm *job) {
+	Q.logMu.Lock()
+	if logs, ok := Q.log[itm.conn]; ok {
+		delete(logs, itm.key)
+		if len(logs) == 0 {
+			// 断开的连接不再保留.
+			delete(Q.log, itm.conn)
+		}
+	}
+	Q.logMu.Unlock()
+	itm.conn = nil
+}
+
+// retry 任务执行失败，重新放回队列, 超过最大执行次数则放入死信队列, 调用方需要持有任务分片锁.
+func (Q *queue) retry(itm *job, reason string) {
+	itm.reason = reason
+	var attempts int
+	Q.withTube(itm.tube, func(tubes *li) {
+		attempts = tubes.attempts
+	})
+	if attempts > 0 && itm.attempts >= attempts {
+		itm.tube += DeadSuffix
+		Q.fail(itm)
+	}
+	Q.ready(itm)
+	// 重启后预订的任务会恢复为等待执行, 日志写入失败不影响恢复.
+	Q.record("Restore", itm)
+}
+
+// putBack 连接没有收到的任务放回队列, 撤销执行次数, 不放入死信队列, 调用方需要持有任务分片锁并已经取消预订.
+func (Q *queue) putBack(itm *job) {
+	itm.attempts--
+	itm.pending = false
+	Q.ready(itm)
+	Q.record("Restore", itm)
+}
+
+// giveBack 连接断开时将刚获取的任务放回队列, 不计入执行次数.
+func (Q *queue) giveBack(key string, conn interface{}) {
+	s := Q.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	if itm, ok := s.jobs[key]; ok && itm.status == RESERVED && itm.conn == conn {
+		Q.unreserve(itm)
+		Q.putBack(itm)
+	}
+}
+
+// delivered 直接交给等待连接的任务已经被连接收到.
+func (Q *queue) delivered(key string) {
+	s := Q.shard(key)
+	s.Lock()
+	defer s.Unlock()
+
+	if itm, ok := s.jobs[key]; ok {
+		itm.pending = false
+	}
+}
+
+// fail 调用任务失败回调函数.
+func (Q *queue) fail(itm *job) {
+	Q.RLock()
+	f := Q.onFail
+	Q.RUnlock()
+
+	if f != nil {
+		f(itm.key, itm.reason, itm.result)
+	}
+}
+
+// jobTTR 获取任务执行时间限制, 任务没有设置使用队列配置.
+func (Q *queue) jobTTR(itm *job) time.Duration {
+	if itm.ttr > 0 {
+
+		return itm.ttr
+	}
+
+	var ttr time.Duration
+	if tubes := Q.findTube(itm.tube); tubes != nil {
+		tubes.Lock()
+		ttr = tubes.ttr
+		tubes.Unlock()
+	}
+
+	return ttr
+}
+
+// findTube 获取一个队列，不存在返回nil.
+func (Q *queue) findTube(tube string) *li {
+	Q.RLock()
+	defer Q.RUnlock()
+
+	return Q.tube[tube]
 }
 
-// registerMessage 设置一个消息通知.
-func (Q *queue) registerMessage(tube string) chan interface{} {
-	c := make(chan interface{}, 2)
+// getTube 获取一个队列，不存在则新建.
+func (Q *queue) getTube(tube string) *li {
+	if tubes := Q.findTube(tube); tubes != nil {
+
+		return tubes
+	}
+
 	Q.Lock()
 	defer Q.Unlock()
 
 	tubes, ok := Q.tube[tube]
 	if !ok {
 		tubes = &li{
-			list:     NewListed(),
-			channels: make(map[chan interface{}]interface{}),
+			list:       NewPriorityListed(),
+			buried:     NewListed(),
+			waiters:    list.New(),
+			updateTime: time.Now(),
 		}
 		Q.tube[tube] = tubes
 	}
-	tubes.channels[c] = nil
 
-	return c
+	return tubes
 }
 
-// existsQueue 判定指定消息队列中，是否存在队列.
-func (Q *queue) existsQueue(tube string) (bool, error) {
-	Q.RLock()
-	defer Q.RUnlock()
+// configured 判定队列是否设置了配置, 调用方需要持有队列锁.
+func (tubes *li) configured() bool {
 
-	if tubes := Q.tube[tube]; tubes != nil {
-		if tubes.list.Length() > 0 {
+	return tubes.ttr > 0 || tubes.attempts > 0
+}
 
-			return true, nil
+// withTube 持有队列锁执行f, 队列不存在则新建, 队列已经被清除时重新获取.
+func (Q *queue) withTube(tube string, f func(tubes *li)) {
+	for {
+		tubes := Q.getTube(tube)
+		tubes.Lock()
+		if !tubes.removed {
+			f(tubes)
+			tubes.Unlock()
+
+			return
 		}
+		tubes.Unlock()
 	}
+}
 
-	return false, nil
+// ready 任务放入队列，等待执行, 最早等待的连接等待预订任务时直接获得任务, 否则通知最早等待的连接, 调用方需要持有任务分片锁.
+func (Q *queue) ready(itm *job) {
+	itm.status = READY
+	itm.seq = atomic.AddUint64(&Q.seq, 1)
+	var w *waiter
+	Q.withTube(itm.tube, func(tubes *li) {
+		tubes.updateTime = time.Now()
+		if w = tubes.handoff(itm); w == nil {
+			tubes.list.PutPriority(itm.key, itm.priority)
+			tubes.wakeOne()
+		}
+	})
+	if w != nil {
+		Q.reserveJob(itm, w.conn)
+		itm.pending = true
+		w.wake()
+	}
 }
 
-// deregisterMessage  注销一个消息.
-func (Q *queue) deregisterMessage(tube string, c chan interface{}) error {
-	Q.Lock()
-	defer Q.Unlock()
+// 定时项类型.
+const (
+	_ uint8 = iota
+	// timerTTR 任务执行超时.
+	timerTTR
+	// timerDelay 延迟任务到期.
+	timerDelay
+	// timerExpire 已完成的任务到期删除.
+	timerExpire
+)
 
-	if tubes, ok := Q.tube[tube]; ok {
-		delete(tubes.channels, c)
+// fire 定时项到期处理.
+func (Q *queue) fire(t *timer.Item) {
+	s := Q.shard(t.Key)
+	s.Lock()
+	defer s.Unlock()
 
-		return nil
+	itm, ok := s.jobs[t.Key]
+	if !ok {
+
+		return
 	}
 
-	return nil
+	switch t.Kind {
+	case timerTTR:
+		// 预订过期，任务重新放回队列.
+		if itm.status == RESERVED && itm.deadline.Equal(t.When) {
+			Q.unreserve(itm)
+			Q.retry(itm, "执行超时")
+		}
+	case timerDelay:
+		// 延迟任务到期.
+		if itm.status == WAITING && itm.readyAt.Equal(t.When) {
+			Q.ready(itm)
+		}
+	case timerExpire:
+		// 已完成或者失败的任务删除.
+		if itm.status == DELAYED || itm.status == FAILED {
+			delete(s.jobs, t.Key)
+			Q.engine.Delete(t.Key)
+		}
+	}
 }
 
-// GetDb 获取DB数据.
-func (Q *queue) GetDb(key string) ([]byte, bool) {
-	Q.RLock()
-	defer Q.RUnlock()
+// StatsTube 获取队列统计信息.
+func (Q *queue) StatsTube(tube string) (*TubeStats, bool) {
+	tubes := Q.findTube(tube)
+	if tubes == nil {
+
+		return nil, false
+	}
+	tubes.Lock()
+	defer tubes.Unlock()
+
+	return &TubeStats{
+		Ready:    tubes.list.Length(),
+		Priority: tubes.list.Depths(),
+	}, true
+}
+
+// Peek 查看队列中第一个指定状态的任务, 不修改任务状态, 支持READY、RESERVED、BURIED.
+func (Q *queue) Peek(tube string, status uint8) (*JobInfo, bool) {
+	tubes := Q.findTube(tube)
+	if tubes == nil {
+
+		return nil, false
+	}
+
+	switch status {
+	case READY, BURIED:
+		var key string
+		var ok bool
+		tubes.Lock()
+		if status == READY {
+			key, ok = tubes.list.Peek()
+		} else {
+			key, ok = tubes.buried.Peek()
+		}
+		tubes.Unlock()
+		if ok {
 
-	off := h32.DefaultHash.GetOffset(key, BlockSize, BucketSize)
-	if bucket := Q.db[off]; bucket != nil {
-		if itm, ok := bucket[key]; ok {
+			return Q.peek(key, tube, status)
+		}
+	case RESERVED:
+		// 查找最早创建的正在进行中的任务.
+		Q.logMu.Lock()
+		keys := make([]string, 0)
+		for _, logs := range Q.log {
+			for key := range logs {
+				keys = append(keys, key)
+			}
+		}
+		Q.logMu.Unlock()
 
-			return itm.value, ok
+		var first *JobInfo
+		for _, key := range keys {
+			if info, ok := Q.peek(key, tube, status); ok {
+				if first == nil || info.Age > first.Age {
+					first = info
+				}
+			}
 		}
+
+		return first, first != nil
 	}
 
 	return nil, false
 }
 
-// RestoreAll 还原一个连接对象正在做的任务进行还原.
-func (Q *queue) RestoreAll(conn interface{}) error {
-	if logs, ok := Q.log[conn]; ok && logs != nil {
-		for key := range logs {
-			Q.RestoreOne(key, conn)
-		}
+// peek 获取指定队列与状态的任务信息.
+func (Q *queue) peek(key, tube string, status uint8) (*JobInfo, bool) {
+	s := Q.shard(key)
+	s.RLock()
+	defer s.RUnlock()
 
-		Q.Lock()
-		defer Q.Unlock()
-		delete(Q.log, conn)
+	if itm := Q.getJob(key); itm != nil && itm.tube == tube && itm.status == status {
+
+		return Q.info(itm), true
 	}
 
-	return nil
+	return nil, false
+}
+
+// Info 查看任务信息, 不修改任务状态.
+func (Q *queue) Info(key string) (*JobInfo, bool) {
+	s := Q.shard(key)
+	s.RLock()
+	defer s.RUnlock()
+
+	if itm := Q.getJob(key); itm != nil {
+
+		return Q.info(itm), true
+	}
+
+	return nil, false
+}
+
+// shard 获取key所在的任务分片.
+func (Q *queue) shard(key string) *shard {
+
+	return Q.db[h32.DefaultHash.GetOffset(key, BlockSize, BucketSize)]
+}
+
+// getJob 获取任务, 调用方需要持有任务分片锁.
+func (Q *queue) getJob(key string) *job {
+
+	return Q.shard(key).jobs[key]
+}
+
+// info 任务信息, 调用方需要持有任务分片锁.
+func (Q *queue) info(itm *job) *JobInfo {
+	value, _ := Q.engine.Get(itm.key)
+
+	return &JobInfo{
+		Key:      itm.key,
+		Tube:     itm.tube,
+		Value:    value,
+		Status:   itm.status,
+		Age:      time.Now().Sub(itm.createTime),
+		Attempts: itm.attempts,
+		Reason:   itm.reason,
+		Result:   itm.result,
+		Conn:     itm.conn,
+	}
 }
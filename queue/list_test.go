@@ -0,0 +1,233 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+// gcTime 测试使用的空队列检查周期.
+const gcTime = 10 * time.Millisecond
+
+// waitGC 等待空队列检查执行多次.
+func waitGC() {
+	time.Sleep(gcTime * 10)
+}
+
+// TestTubeConfigSurvivesGC 设置了配置的空队列不会被回收.
+func TestTubeConfigSurvivesGC(t *testing.T) {
+	Q := NewQueue(gcTime, nil).(*queue)
+	Q.SetTTR("stock", 5*time.Second)
+	Q.SetMaxAttempts("stock", 3)
+	waitGC()
+
+	tubes := Q.findTube("stock")
+	if tubes == nil {
+		t.Fatal("configured tube was collected")
+	}
+	tubes.Lock()
+	ttr, attempts := tubes.ttr, tubes.attempts
+	tubes.Unlock()
+	if ttr != 5*time.Second || attempts != 3 {
+		t.Fatalf("ttr = %v, attempts = %d, want 5s, 3", ttr, attempts)
+	}
+}
+
+// TestTubeTTRAfterGC 空队列回收检查之后获取的任务仍然使用队列的执行时间限制.
+func TestTubeTTRAfterGC(t *testing.T) {
+	Q := NewQueue(gcTime, nil).(*queue)
+	Q.SetTTR("stock", 20*time.Millisecond)
+	waitGC()
+
+	conn := &struct{}{}
+	Q.Join("stock", "job", []byte("value"), 0, 0, 1024, ResultTTL)
+	if _, _, ok := Q.GetAndDoing("stock", conn); !ok {
+		t.Fatal("reserve failed")
+	}
+	waitGC()
+
+	if info, ok := Q.Info("job"); !ok || info.Status != READY {
+		t.Fatalf("job was not released after ttr: %+v", info)
+	}
+}
+
+// TestIdleTubeCollected 没有配置与任务的空队列超过保留时间后回收, 新建的队列不会立即回收.
+func TestIdleTubeCollected(t *testing.T) {
+	Q := NewQueue(gcTime, nil).(*queue)
+	Q.getTube("fresh")
+	idle := Q.getTube("idle")
+	idle.Lock()
+	idle.updateTime = time.Now().Add(-25 * time.Hour)
+	idle.Unlock()
+	waitGC()
+
+	if Q.findTube("fresh") == nil {
+		t.Fatal("new tube was collected")
+	}
+	if Q.findTube("idle") != nil {
+		t.Fatal("idle tube was not collected")
+	}
+}
+
+// TestMaxAttemptsAfterGC 空队列回收检查之后, 超过最大执行次数的任务仍然放入死信队列.
+func TestMaxAttemptsAfterGC(t *testing.T) {
+	Q := NewQueue(gcTime, nil).(*queue)
+	var failed []string
+	Q.SetFailHandler(func(key, reason string, result uint8) {
+		failed = append(failed, key)
+	})
+	Q.SetMaxAttempts("stock", 1)
+	waitGC()
+
+	conn := &struct{}{}
+	Q.Join("stock", "job", []byte("value"), 0, 0, 1024, ResultTTL)
+	if _, _, ok := Q.GetAndDoing("stock", conn); !ok {
+		t.Fatal("reserve failed")
+	}
+	Q.RestoreOne("job", conn)
+
+	info, ok := Q.Info("job")
+	if !ok || info.Tube != "stock"+DeadSuffix {
+		t.Fatalf("job was not moved to the dead letter tube: %+v", info)
+	}
+	if len(failed) != 1 || failed[0] != "job" {
+		t.Fatalf("fail handler calls = %v, want [job]", failed)
+	}
+	if _, _, ok := Q.GetAndDoing("stock", conn); ok {
+		t.Fatal("dead job was reserved from the original tube")
+	}
+}
+
+// TestReserveWaitClosedConn 同一个连接上的多个等待都在连接断开时退出, 之后加入的任务不会交给断开的连接.
+func TestReserveWaitClosedConn(t *testing.T) {
+	Q := NewQueue(time.Minute, nil).(*queue)
+	conn := &struct{}{}
+	ch := make(chan interface{})
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, _, err := Q.ReserveWait("stock", conn, time.Second, ch)
+			errs <- err
+		}()
+	}
+	// 等待两个连接都加入等待队列.
+	time.Sleep(20 * time.Millisecond)
+	close(ch)
+	Q.RestoreAll(conn)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil || err.Error() != "EOF" {
+			t.Fatalf("ReserveWait err = %v, want EOF", err)
+		}
+	}
+
+	for _, key := range []string{"job-1", "job-2"} {
+		Q.Join("stock", key, []byte("value"), 0, 0, 1024, ResultTTL)
+		if info, ok := Q.Info(key); !ok || info.Status != READY {
+			t.Fatalf("%s was reserved by a closed connection: %+v", key, info)
+		}
+	}
+}
+
+// TestReserveAnyClosedConn 连接断开后获取的任务放回队列.
+func TestReserveAnyClosedConn(t *testing.T) {
+	Q := NewQueue(time.Minute, nil).(*queue)
+	conn := &struct{}{}
+	ch := make(chan interface{})
+	close(ch)
+	Q.Join("stock", "job", []byte("value"), 0, 0, 1024, ResultTTL)
+
+	if _, key, _, err := Q.ReserveAny([]string{"stock"}, conn, time.Second, ch); err == nil {
+		t.Fatalf("reserved %s on a closed connection", key)
+	}
+	if info, ok := Q.Info("job"); !ok || info.Status != READY {
+		t.Fatalf("job was not put back: %+v", info)
+	}
+}
+
+// TestFinishStaleWorker 预订过期的Worker不能保存结果, 保存失败时任务保持预订.
+func TestFinishStaleWorker(t *testing.T) {
+	Q := NewQueue(time.Minute, nil).(*queue)
+	Q.SetTTR("stock", 20*time.Millisecond)
+	Q.Join("stock", "job", []byte("value"), 0, 0, 1024, ResultTTL)
+	stale, owner := &struct{ n int }{1}, &struct{ n int }{2}
+	if _, _, ok := Q.GetAndDoing("stock", stale); !ok {
+		t.Fatal("reserve failed")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, _, ok := Q.GetAndDoing("stock", owner); !ok {
+		t.Fatal("reserve after ttr failed")
+	}
+
+	var result string
+	if err := Q.Finish("job", owner, func() error {
+		return errDisk
+	}); err != errDisk {
+		t.Fatalf("Finish err = %v, want %v", err, errDisk)
+	}
+	if info, ok := Q.Info("job"); !ok || info.Status != RESERVED || info.Conn != owner {
+		t.Fatalf("job not reserved by owner after store error: %+v", info)
+	}
+	if err := Q.Finish("job", stale, func() error {
+		result = "stale"
+		return nil
+	}); err != ErrNotReserved {
+		t.Fatalf("stale Finish err = %v, want %v", err, ErrNotReserved)
+	}
+	if err := Q.Finish("job", owner, func() error {
+		result = "owner"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Q.Finish("job", stale, func() error {
+		result = "stale"
+		return nil
+	}); err != ErrFinished {
+		t.Fatalf("stale Finish err = %v, want %v", err, ErrFinished)
+	}
+	if result != "owner" {
+		t.Fatalf("result = %s, want owner", result)
+	}
+}
+
+// TestHandoffClosedConn 直接交给等待连接的任务, 连接收到之前断开时放回原队列, 不计入执行次数, 不放入死信队列.
+func TestHandoffClosedConn(t *testing.T) {
+	Q := NewQueue(time.Minute, nil).(*queue)
+	Q.SetMaxAttempts("stock", 1)
+	conn := &struct{}{}
+	w := newWaiter(conn)
+	Q.wait([]string{"stock"}, w)
+	Q.Join("stock", "job", []byte("value"), 0, 0, 1024, ResultTTL)
+	if _, key, _ := w.cancel(); key != "job" {
+		t.Fatalf("handoff key = %q, want job", key)
+	}
+	// 等待的连接收到任务之前断开.
+	Q.RestoreAll(conn)
+
+	info, ok := Q.Info("job")
+	if !ok || info.Status != READY || info.Tube != "stock" || info.Attempts != 0 {
+		t.Fatalf("job after handoff to closed connection: %+v", info)
+	}
+	Q.logMu.Lock()
+	_, logged := Q.log[conn]
+	Q.logMu.Unlock()
+	if logged {
+		t.Fatal("reserve log of closed connection was kept")
+	}
+}
+
+// TestReserveLogRemoved 连接预订的任务全部完成后不保留预订记录.
+func TestReserveLogRemoved(t *testing.T) {
+	Q := NewQueue(time.Minute, nil).(*queue)
+	conn := &struct{}{}
+	Q.Join("stock", "job", []byte("value"), 0, 0, 1024, ResultTTL)
+	if _, _, ok := Q.GetAndDoing("stock", conn); !ok {
+		t.Fatal("reserve failed")
+	}
+	Q.Finish("job", conn, nil)
+
+	Q.logMu.Lock()
+	defer Q.logMu.Unlock()
+	if _, ok := Q.log[conn]; ok {
+		t.Fatal("empty reserve log was kept")
+	}
+}
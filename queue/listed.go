@@ -1,5 +1,9 @@
 package queue
 
+import (
+	"sort"
+)
+
 // Listed 链表接口.
 type Listed interface {
 	// 入队.
@@ -8,6 +12,8 @@ type Listed interface {
 	Out() (string, bool)
 	// 队列长度.
 	Length() int
+	// 查看第一个数据，不出队.
+	Peek() (string, bool)
 }
 
 // head 头部数据结构体.
@@ -69,6 +75,16 @@ func (h *head) Length() int {
 	return h.len
 }
 
+// Peek 查看第一个数据.
+func (h *head) Peek() (string, bool) {
+	if h.first == nil {
+
+		return "", false
+	}
+
+	return h.first.value, true
+}
+
 // NewListed 新建一个链表.
 func NewListed() Listed {
 
@@ -80,3 +96,103 @@ func NewListed() Listed {
 
 	return h
 }
+
+// DefaultPriority 默认任务优先级, 数字越小越优先.
+const DefaultPriority uint32 = 1024
+
+// PriorityListed 优先级链表接口, 数字越小越先出队, 同一优先级先进先出.
+type PriorityListed interface {
+	Listed
+	// 按优先级入队.
+	PutPriority(string, uint32) error
+	// 各优先级队列长度.
+	Depths() map[uint32]int
+}
+
+// priority 优先级链表结构体.
+type priority struct {
+	len   int              // 链表数据长度.
+	pris  []uint32         // 存在数据的优先级, 从小到大排序.
+	lists map[uint32]*head // 各优先级链表.
+}
+
+// Put 按默认优先级添加一个数据.
+func (p *priority) Put(b string) error {
+
+	return p.PutPriority(b, DefaultPriority)
+}
+
+// PutPriority 按优先级添加一个数据.
+func (p *priority) PutPriority(b string, pri uint32) error {
+	h, ok := p.lists[pri]
+	if !ok {
+		h = NewListed().(*head)
+		p.lists[pri] = h
+		i := sort.Search(len(p.pris), func(i int) bool { return p.pris[i] >= pri })
+		p.pris = append(p.pris, 0)
+		copy(p.pris[i+1:], p.pris[i:])
+		p.pris[i] = pri
+	}
+	p.len++
+
+	return h.Put(b)
+}
+
+// Out 取出优先级最高的一个数据.
+func (p *priority) Out() (string, bool) {
+	if len(p.pris) == 0 {
+
+		return "", false
+	}
+
+	pri := p.pris[0]
+	h := p.lists[pri]
+	val, ok := h.Out()
+	if h.Length() == 0 {
+		delete(p.lists, pri)
+		p.pris = p.pris[1:]
+	}
+	if ok {
+		p.len--
+	}
+
+	return val, ok
+}
+
+// Length 获取数据长度.
+func (p *priority) Length() int {
+
+	return p.len
+}
+
+// Peek 查看优先级最高的第一个数据.
+func (p *priority) Peek() (string, bool) {
+	if len(p.pris) == 0 {
+
+		return "", false
+	}
+
+	return p.lists[p.pris[0]].Peek()
+}
+
+// Depths 各优先级数据长度.
+func (p *priority) Depths() map[uint32]int {
+	m := make(map[uint32]int, len(p.pris))
+	for pri, h := range p.lists {
+		m[pri] = h.Length()
+	}
+
+	return m
+}
+
+// NewPriorityListed 新建一个优先级链表.
+func NewPriorityListed() PriorityListed {
+
+	p := &priority{
+		len:   0,
+		pris:  make([]uint32, 0, 1),
+		lists: make(map[uint32]*head, 1),
+	}
+
+	return p
+}
@@ -0,0 +1,97 @@
+package queue
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestListed 链表先进先出.
+func TestListed(t *testing.T) {
+	l := NewListed()
+	for _, key := range []string{"a", "b", "c"} {
+		l.Put(key)
+	}
+	if key, ok := l.Peek(); !ok || key != "a" {
+		t.Fatalf("Peek = %q, %v, want a", key, ok)
+	}
+	var got []string
+	for key, ok := l.Out(); ok; key, ok = l.Out() {
+		got = append(got, key)
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("out = %v, want %v", got, want)
+	}
+	if l.Length() != 0 {
+		t.Fatalf("length = %d, want 0", l.Length())
+	}
+}
+
+// TestPriorityListed 数字越小越先出队, 同一优先级先进先出.
+func TestPriorityListed(t *testing.T) {
+	type put struct {
+		key string
+		pri uint32
+	}
+	for _, c := range []struct {
+		name string
+		puts []put
+		want []string
+	}{
+		{"empty", nil, nil},
+		{"fifo", []put{{"a", 5}, {"b", 5}, {"c", 5}}, []string{"a", "b", "c"}},
+		{"lowest first", []put{{"a", 9}, {"b", 1}, {"c", 5}}, []string{"b", "c", "a"}},
+		{"fifo within priority", []put{{"a", 2}, {"b", 1}, {"c", 2}, {"d", 1}, {"e", 0}}, []string{"e", "b", "d", "a", "c"}},
+		{"default priority", []put{{"a", DefaultPriority}, {"b", DefaultPriority + 1}, {"c", DefaultPriority - 1}}, []string{"c", "a", "b"}},
+		{"max priority", []put{{"a", ^uint32(0)}, {"b", 0}}, []string{"b", "a"}},
+	} {
+		l := NewPriorityListed()
+		depths := make(map[uint32]int)
+		for _, p := range c.puts {
+			l.PutPriority(p.key, p.pri)
+			depths[p.pri]++
+		}
+		if got := l.Depths(); !reflect.DeepEqual(got, depths) {
+			t.Errorf("%s: depths = %v, want %v", c.name, got, depths)
+		}
+		if l.Length() != len(c.want) {
+			t.Errorf("%s: length = %d, want %d", c.name, l.Length(), len(c.want))
+		}
+		var got []string
+		for {
+			peek, peeked := l.Peek()
+			key, ok := l.Out()
+			if peek != key || peeked != ok {
+				t.Errorf("%s: Peek = %q, %v, Out = %q, %v", c.name, peek, peeked, key, ok)
+			}
+			if !ok {
+				break
+			}
+			got = append(got, key)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: out = %v, want %v", c.name, got, c.want)
+		}
+		if l.Length() != 0 || len(l.Depths()) != 0 {
+			t.Errorf("%s: length = %d, depths = %v after out", c.name, l.Length(), l.Depths())
+		}
+	}
+}
+
+// TestPriorityListedInterleaved 出队之后再入队, 新的更高优先级先出队.
+func TestPriorityListedInterleaved(t *testing.T) {
+	l := NewPriorityListed()
+	l.PutPriority("a", 5)
+	l.PutPriority("b", 5)
+	if key, _ := l.Out(); key != "a" {
+		t.Fatalf("out = %q, want a", key)
+	}
+	l.PutPriority("c", 1)
+	l.PutPriority("d", 5)
+	var got []string
+	for key, ok := l.Out(); ok; key, ok = l.Out() {
+		got = append(got, key)
+	}
+	if want := []string{"c", "b", "d"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("out = %v, want %v", got, want)
+	}
+}
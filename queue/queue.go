@@ -1,7 +1,11 @@
 package queue
 
 import (
+	"errors"
 	"time"
+
+	"../store"
+	"../timer"
 )
 
 // BlockSize 数据存储的数组大小.
@@ -10,27 +14,98 @@ const BlockSize = 100
 // BucketSize 数据分块存储的桶.
 const BucketSize = 10000
 
+// ErrNotFound 任务不存在.
+var ErrNotFound = errors.New("not found")
+
+// ErrNotReserved 任务不是由当前连接预订.
+var ErrNotReserved = errors.New("not reserved")
+
+// ErrJournal 日志记录格式错误.
+var ErrJournal = errors.New("journal record error")
+
+// ErrFinished 任务已经完成.
+var ErrFinished = errors.New("finished")
+
+// Admin 管理员连接标示, 可以操作任何连接预订的任务.
+var Admin interface{} = &struct{ name string }{"admin"}
+
+// DeadSuffix 死信队列名称后缀, 超过最大执行次数的任务放入"<tube>.dead"队列.
+const DeadSuffix = ".dead"
+
 // Queue 队列接口.
 type Queue interface {
 	// Join 向队列中，添加一个任务.
-	Join(tube, key string, value []byte) error
-	// Finish 完成一个任务.
-	Finish(key string, conn interface{}) bool
+	Join(tube, key string, value []byte, ttr, delay time.Duration, pri uint32, result uint8) error
+	// Finish 完成一个任务, 任务必须由当前连接预订, store不为nil时在任务锁内保存结果.
+	Finish(key string, conn interface{}, store func() error) error
 	// GetAndDoing 获取一个任务，修改任务状态为正在开始中.
 	GetAndDoing(tube string, conn interface{}) (string, []byte, bool)
+	// ReserveWait 获取一个任务并预订, 队列中没有任务时等待, 超时返回timeout错误.
+	ReserveWait(tube string, conn interface{}, timeout time.Duration, ch chan interface{}) (string, []byte, error)
+	// ReserveAny 按顺序从多个队列中获取一个任务并预订, 返回任务所在的队列, 所有队列都没有任务时等待.
+	ReserveAny(tubes []string, conn interface{}, timeout time.Duration, ch chan interface{}) (string, string, []byte, error)
 	// Exists 判定一个人是否存在, 该任务必须为未开始，正在完成中.
 	Exists(key string) bool
-	// RestoreOne 还原一个任务.
+	// RestoreOne 还原一个任务, 任务必须由conn预订.
 	RestoreOne(tube string, conn interface{}) bool
 	// Usr1 添加一个通知，如果有数据，通知用户.
 	Usr1(tube string, ch chan interface{}) (bool, error)
 	// RestoreAll 还原一个连接对象正在做的任务进行还原.
 	RestoreAll(conn interface{}) error
+	// Touch 延长一个正在进行中任务的执行时间.
+	Touch(key string, conn interface{}) bool
+	// SetTTR 设置队列任务执行时间限制.
+	SetTTR(tube string, ttr time.Duration) error
+	// StatsTube 获取队列统计信息.
+	StatsTube(tube string) (*TubeStats, bool)
+	// SetMaxAttempts 设置队列任务最大执行次数, 超过后任务放入死信队列.
+	SetMaxAttempts(tube string, n int) error
+	// SetFailHandler 设置任务失败或进入死信队列时的回调函数.
+	SetFailHandler(f func(key, reason string, result uint8))
+	// Release 将当前连接预订的任务放回队列, delay大于0时延迟放回.
+	Release(key string, conn interface{}, delay time.Duration) error
+	// Fail 将当前连接预订的任务标记为失败.
+	Fail(key string, conn interface{}, reason string) error
+	// Bury 将当前连接预订的任务埋葬, 埋葬的任务不会被获取和回收.
+	Bury(key string, conn interface{}, reason string) error
+	// Kick 将队列中最多n个被埋葬的任务放回队列, 返回放回的任务数.
+	Kick(tube string, n int) (int, error)
+	// Peek 查看队列中第一个指定状态的任务, 不修改任务状态.
+	Peek(tube string, status uint8) (*JobInfo, bool)
+	// Info 查看任务信息, 不修改任务状态.
+	Info(key string) (*JobInfo, bool)
+	// SetJournal 设置日志, 任务状态变化都会写入日志.
+	SetJournal(j Journal)
+	// Replay 重放一条日志记录.
+	Replay(rec [][]byte) error
+	// Recover 日志重放完成后重建队列.
+	Recover() error
+	// Snapshot 将当前队列配置与未完成的任务写入快照.
+	Snapshot(w Journal) error
 	// StartAndGC GC数据回收.
 	StartAndGC() error
 }
 
-// READY 等待状态 RESERVED 进行中状态 DELAYED 可以删除状态.
+// TubeStats 队列统计信息.
+type TubeStats struct {
+	Ready    int            // 等待执行任务数.
+	Priority map[uint32]int // 各优先级等待执行任务数.
+}
+
+// JobInfo 任务信息.
+type JobInfo struct {
+	Key      string        // 唯一ID标示.
+	Tube     string        // 消息队列名称.
+	Value    []byte        // 数据.
+	Status   uint8         // 任务状态.
+	Age      time.Duration // 创建至今的时间.
+	Attempts int           // 已被获取执行的次数.
+	Reason   string        // 最后一次失败原因.
+	Result   uint8         // 结果保留策略.
+	Conn     interface{}   // 预订任务的连接.
+}
+
+// READY 等待状态 RESERVED 进行中状态 DELAYED 可以删除状态 WAITING 延迟等待状态 FAILED 失败状态 BURIED 埋葬状态.
 const (
 	_ uint8 = iota
 	// READY 等待状态.
@@ -39,16 +114,62 @@ const (
 	RESERVED
 	// DELAYED 可以删除状态.
 	DELAYED
+	// WAITING 延迟等待状态, 到期后进入等待状态.
+	WAITING
+	// FAILED 失败状态, 可以删除.
+	FAILED
+	// BURIED 埋葬状态, 等待人工处理后Kick放回队列.
+	BURIED
 )
 
-// NewQueue 创建一个默认队列.
-func NewQueue(gcTime time.Duration) Queue {
+// ResultTTL 结果保留到有效期结束 ResultOnce 结果第一次读取后删除 ResultKeep 结果保留到被删除.
+const (
+	// ResultTTL 结果保留到有效期结束.
+	ResultTTL uint8 = iota
+	// ResultOnce 结果第一次读取后删除, 没有读取时保留到有效期结束.
+	ResultOnce
+	// ResultKeep 结果保留到被删除.
+	ResultKeep
+)
+
+// StatusName 获取任务状态名称.
+func StatusName(status uint8) string {
+	switch status {
+	case READY:
+		return "ready"
+	case RESERVED:
+		return "reserved"
+	case DELAYED:
+		return "finished"
+	case WAITING:
+		return "delayed"
+	case FAILED:
+		return "failed"
+	case BURIED:
+		return "buried"
+	}
+
+	return "unknown"
+}
+
+// NewQueue 创建一个默认队列, engine存储任务数据, 为nil使用内存存储引擎.
+func NewQueue(gcTime time.Duration, engine store.Engine) Queue {
+	if engine == nil {
+		engine = store.NewMemory()
+	}
 	q := &queue{
-		tube: make(map[string]*li, 0),
-		log:  make(map[interface{}]map[string]interface{}, 0),
-		db:   make([]map[string]*job, BlockSize),
-		dur:  gcTime,
+		tube:   make(map[string]*li, 0),
+		log:    make(map[interface{}]map[string]interface{}, 0),
+		db:     make([]*shard, BlockSize),
+		dur:    gcTime,
+		engine: engine,
+	}
+	for k := range q.db {
+		q.db[k] = &shard{
+			jobs: make(map[string]*job),
+		}
 	}
+	q.timer = timer.New(q.fire)
 	q.StartAndGC()
 
 	return q
@@ -0,0 +1,154 @@
+package queue
+
+import (
+	"container/list"
+	"sync"
+)
+
+// waiter 等待任务的连接, 可以同时在多个队列中等待, 只会被一个队列唤醒.
+// 加锁顺序: 单个队列锁 -> 等待者锁.
+type waiter struct {
+	sync.Mutex                  // 锁.
+	conn       interface{}      // 等待预订任务的连接, 为nil时只通知有新任务.
+	c          chan interface{} // 唤醒通知.
+	done       bool             // 已经被唤醒或者退出等待.
+	woken      bool             // 已经被通知有新任务.
+	tube       string           // 直接获得的任务所在的队列.
+	key        string           // 直接获得的任务.
+}
+
+// newWaiter 创建一个等待者, conn为nil时只通知有新任务.
+func newWaiter(conn interface{}) *waiter {
+
+	return &waiter{
+		conn: conn,
+		c:    make(chan interface{}, 1),
+	}
+}
+
+// take 直接获得任务, 已经退出等待返回false.
+func (w *waiter) take(tube, key string) bool {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.done {
+
+		return false
+	}
+	w.done = true
+	w.tube = tube
+	w.key = key
+
+	return true
+}
+
+// notify 通知有新任务, 已经退出等待返回false.
+func (w *waiter) notify() bool {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.done {
+
+		return false
+	}
+	w.done = true
+	w.woken = true
+	w.wake()
+
+	return true
+}
+
+// wake 唤醒等待者.
+func (w *waiter) wake() {
+	select {
+	case w.c <- nil:
+	default:
+	}
+}
+
+// cancel 退出等待, 返回直接获得的任务, 以及是否已经被通知有新任务.
+func (w *waiter) cancel() (string, string, bool) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.done = true
+
+	return w.tube, w.key, w.woken
+}
+
+// wait 加入多个队列的等待队列.
+func (Q *queue) wait(names []string, w *waiter) []*list.Element {
+	elems := make([]*list.Element, len(names))
+	for i, tube := range names {
+		Q.withTube(tube, func(tubes *li) {
+			elems[i] = tubes.waiters.PushBack(w)
+		})
+	}
+
+	return elems
+}
+
+// unwait 退出多个队列的等待队列.
+func (Q *queue) unwait(names []string, elems []*list.Element) {
+	for i, tube := range names {
+		if tubes := Q.findTube(tube); tubes != nil {
+			tubes.Lock()
+			tubes.waiters.Remove(elems[i])
+			tubes.Unlock()
+		}
+	}
+}
+
+// existsAny 判定多个队列中是否存在等待执行的任务.
+func (Q *queue) existsAny(names []string) bool {
+	for _, tube := range names {
+		if ok, _ := Q.existsQueue(tube); ok {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// wakeAny 存在等待执行任务的队列唤醒最早等待的连接.
+func (Q *queue) wakeAny(names []string) {
+	for _, tube := range names {
+		if tubes := Q.findTube(tube); tubes != nil {
+			tubes.Lock()
+			if tubes.list.Length() > 0 {
+				tubes.wakeOne()
+			}
+			tubes.Unlock()
+		}
+	}
+}
+
+// handoff 最早等待的连接等待预订任务时直接获得任务, 返回获得任务的等待者, 调用方需要持有队列锁.
+func (tubes *li) handoff(itm *job) *waiter {
+	for e := tubes.waiters.Front(); e != nil; e = tubes.waiters.Front() {
+		w := e.Value.(*waiter)
+		if w.conn == nil {
+
+			return nil
+		}
+		tubes.waiters.Remove(e)
+		if w.take(itm.tube, itm.key) {
+
+			return w
+		}
+	}
+
+	return nil
+}
+
+// wakeOne 通知最早等待的连接有新任务, 调用方需要持有队列锁.
+func (tubes *li) wakeOne() {
+	for e := tubes.waiters.Front(); e != nil; e = tubes.waiters.Front() {
+		tubes.waiters.Remove(e)
+		if e.Value.(*waiter).notify() {
+
+			return
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+// TestHandoffOrder 新任务直接交给最早等待预订的连接, 跳过已经退出等待的连接,
+// 最早的等待者只需要通知时任务进入队列并通知它.
+func TestHandoffOrder(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		waiters []string // reserve: 等待预订, cancelled: 已经退出等待, usr1: 只等待通知.
+		jobs    []string
+		want    []string // 每个等待者的结果, 获得的任务, woken表示被通知, 空表示没有结果.
+		ready   int      // 进入队列等待执行的任务数.
+	}{
+		{
+			name:    "oldest reserve",
+			waiters: []string{"reserve", "reserve"},
+			jobs:    []string{"job1"},
+			want:    []string{"job1", ""},
+		},
+		{
+			name:    "each job to next waiter",
+			waiters: []string{"reserve", "reserve"},
+			jobs:    []string{"job1", "job2", "job3"},
+			want:    []string{"job1", "job2"},
+			ready:   1,
+		},
+		{
+			name:    "skip cancelled",
+			waiters: []string{"cancelled", "reserve"},
+			jobs:    []string{"job1"},
+			want:    []string{"", "job1"},
+		},
+		{
+			name:    "all cancelled",
+			waiters: []string{"cancelled", "cancelled"},
+			jobs:    []string{"job1"},
+			want:    []string{"", ""},
+			ready:   1,
+		},
+		{
+			name:    "usr1 first",
+			waiters: []string{"usr1", "reserve"},
+			jobs:    []string{"job1"},
+			want:    []string{"woken", ""},
+			ready:   1,
+		},
+		{
+			name:    "reserve before usr1",
+			waiters: []string{"reserve", "usr1"},
+			jobs:    []string{"job1", "job2"},
+			want:    []string{"job1", "woken"},
+			ready:   1,
+		},
+		{
+			name:    "cancelled before usr1",
+			waiters: []string{"cancelled", "usr1", "reserve"},
+			jobs:    []string{"job1"},
+			want:    []string{"", "woken", ""},
+			ready:   1,
+		},
+	} {
+		Q := NewQueue(time.Minute, nil).(*queue)
+		waiters := make([]*waiter, len(c.waiters))
+		for i, kind := range c.waiters {
+			var conn interface{}
+			if kind != "usr1" {
+				conn = new(int)
+			}
+			waiters[i] = newWaiter(conn)
+			Q.wait([]string{"stock"}, waiters[i])
+			if kind == "cancelled" {
+				waiters[i].cancel()
+			}
+		}
+		for _, key := range c.jobs {
+			Q.Join("stock", key, []byte("value"), 0, 0, 1024, ResultTTL)
+		}
+
+		for i, w := range waiters {
+			_, key, woken := w.cancel()
+			got := key
+			if woken {
+				got = "woken"
+			}
+			if got != c.want[i] {
+				t.Errorf("%s: waiter %d (%s) = %q, want %q", c.name, i, c.waiters[i], got, c.want[i])
+			}
+			if key == "" {
+				continue
+			}
+			if info, ok := Q.Info(key); !ok || info.Status != RESERVED {
+				t.Errorf("%s: handoff job %s = %+v, want reserved", c.name, key, info)
+			}
+		}
+		if stats, _ := Q.StatsTube("stock"); stats == nil || stats.Ready != c.ready {
+			t.Errorf("%s: stats = %+v, want %d ready jobs", c.name, stats, c.ready)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package queue
+
+import (
+	"sort"
+	"sync"
+)
+
+// Weighted 多个队列的平滑加权轮询, 权重越大的队列越先获取, 权重小的队列也会按比例被获取.
+// 只有真正获取到任务的队列才计入轮询, 没有任务的队列不影响其他队列.
+type Weighted struct {
+	sync.Mutex          // 锁.
+	tubes      []string // 队列名称.
+	weights    []int    // 权重.
+	current    []int    // 当前权重.
+	total      int      // 权重之和.
+}
+
+// NewWeighted 创建一个加权轮询, 权重小于1按1计算.
+func NewWeighted(tubes []string, weights []int) *Weighted {
+	w := &Weighted{
+		tubes:   append([]string(nil), tubes...),
+		weights: make([]int, len(tubes)),
+		current: make([]int, len(tubes)),
+	}
+	for i := range w.weights {
+		w.weights[i] = 1
+		if i < len(weights) && weights[i] > 1 {
+			w.weights[i] = weights[i]
+		}
+		w.total += w.weights[i]
+	}
+
+	return w
+}
+
+// Match 判定队列与权重是否相同.
+func (w *Weighted) Match(tubes []string, weights []int) bool {
+	if len(tubes) != len(w.tubes) || len(weights) != len(w.weights) {
+
+		return false
+	}
+	for i := range tubes {
+		if tubes[i] != w.tubes[i] || weights[i] != w.weights[i] {
+
+			return false
+		}
+	}
+
+	return true
+}
+
+// Order 本次获取任务时队列的先后顺序.
+func (w *Weighted) Order() []string {
+	w.Lock()
+	defer w.Unlock()
+
+	idx := w.order()
+	tubes := make([]string, len(idx))
+	for i, k := range idx {
+		tubes[i] = w.tubes[k]
+	}
+
+	return tubes
+}
+
+// order 按当前权重从大到小排列的队列下标.
+func (w *Weighted) order() []int {
+	idx := make([]int, len(w.tubes))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+
+		return w.current[idx[a]]+w.weights[idx[a]] > w.current[idx[b]]+w.weights[idx[b]]
+	})
+
+	return idx
+}
+
+// Served 记录从队列获取到了任务.
+// 顺序在获取到任务的队列之前的队列没有任务, 不参与本轮轮询, 否则它们的当前权重会一直增长,
+// 其他队列之间的比例也不再与权重一致.
+func (w *Weighted) Served(tube string) {
+	w.Lock()
+	defer w.Unlock()
+
+	idx := w.order()
+	for n, i := range idx {
+		if w.tubes[i] != tube {
+			continue
+		}
+		total := 0
+		for _, k := range idx[n:] {
+			w.current[k] += w.weights[k]
+			total += w.weights[k]
+		}
+		w.current[i] -= total
+
+		return
+	}
+}
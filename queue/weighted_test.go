@@ -0,0 +1,84 @@
+package queue
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// serve 轮询rounds次, 每次从顺序中第一个有任务的队列获取, 返回获取顺序.
+func serve(w *Weighted, rounds int, empty map[string]bool) []string {
+	served := make([]string, 0, rounds)
+	for i := 0; i < rounds; i++ {
+		for _, tube := range w.Order() {
+			if !empty[tube] {
+				w.Served(tube)
+				served = append(served, tube)
+				break
+			}
+		}
+	}
+
+	return served
+}
+
+// TestWeightedSmooth 平滑加权轮询, 权重大的队列不会连续被获取完.
+func TestWeightedSmooth(t *testing.T) {
+	for _, c := range []struct {
+		weights []int
+		want    string
+	}{
+		{[]int{5, 1, 1}, "aabacaa"},
+		{[]int{1, 1, 1}, "abc"},
+		{[]int{2, 1, 0}, "abcaabca"},
+		{[]int{0, 0, 3}, "cacbc"},
+	} {
+		w := NewWeighted([]string{"a", "b", "c"}, c.weights)
+		got := strings.Join(serve(w, len(c.want), nil), "")
+		if got != c.want {
+			t.Errorf("weights %v: order = %s, want %s", c.weights, got, c.want)
+		}
+	}
+}
+
+// TestWeightedRatio 获取次数与权重成比例.
+func TestWeightedRatio(t *testing.T) {
+	for _, c := range []struct {
+		weights []int
+		empty   map[string]bool
+		want    map[string]int
+	}{
+		{[]int{3, 2, 1}, nil, map[string]int{"a": 300, "b": 200, "c": 100}},
+		{[]int{10, 1, 1}, nil, map[string]int{"a": 500, "b": 50, "c": 50}},
+		// 没有任务的队列不计入轮询, 其他队列仍然按权重比例获取.
+		{[]int{3, 2, 1}, map[string]bool{"a": true}, map[string]int{"b": 400, "c": 200}},
+	} {
+		w := NewWeighted([]string{"a", "b", "c"}, c.weights)
+		got := make(map[string]int)
+		for _, tube := range serve(w, 600, c.empty) {
+			got[tube]++
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("weights %v, empty %v: served = %v, want %v", c.weights, c.empty, got, c.want)
+		}
+	}
+}
+
+// TestWeightedMatch 队列与权重相同时复用轮询状态.
+func TestWeightedMatch(t *testing.T) {
+	w := NewWeighted([]string{"a", "b"}, []int{3, 1})
+	for _, c := range []struct {
+		tubes   []string
+		weights []int
+		want    bool
+	}{
+		{[]string{"a", "b"}, []int{3, 1}, true},
+		{[]string{"b", "a"}, []int{1, 3}, false},
+		{[]string{"a", "b"}, []int{3, 2}, false},
+		{[]string{"a"}, []int{3}, false},
+	} {
+		if got := w.Match(c.tubes, c.weights); got != c.want {
+			t.Errorf("Match(%v, %v) = %v, want %v", c.tubes, c.weights, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// headerSize 记录头大小: crc32(4) + key长度(4) + value长度(4).
+const headerSize = 12
+
+// tombstone value长度为-1表示删除记录.
+const tombstone = -1
+
+// compactSize 无效数据超过该大小且超过文件一半时压缩文件.
+var compactSize int64 = 64 << 20
+
+// ErrCorrupt 数据文件损坏.
+var ErrCorrupt = errors.New("store: corrupt record")
+
+// disk 磁盘存储引擎, 数据追加写入单个文件, 内存中只保存key与数据位置的索引.
+// 写入不主动同步到磁盘, 数据持久化由日志保证.
+type disk struct {
+	sync.RWMutex                  // 读写锁.
+	name         string           // 文件名.
+	fd           *os.File         // 文件对象.
+	size         int64            // 文件大小.
+	garbage      int64            // 无效数据大小.
+	index        map[string]entry // 数据位置索引.
+	compacting   bool             // 正在后台压缩文件.
+	closed       bool             // 已经关闭.
+	wg           sync.WaitGroup   // 等待后台压缩完成.
+}
+
+// entry 数据位置.
+type entry struct {
+	offset int64 // 记录在文件中的位置.
+	size   int32 // value长度.
+	klen   int32 // key长度.
+}
+
+// recordSize 记录大小.
+func (e entry) recordSize() int64 {
+
+	return headerSize + int64(e.klen) + int64(e.size)
+}
+
+// OpenDisk 打开一个磁盘存储引擎, 读取已经存在的数据重建索引, 文件尾部不完整的记录会被截断.
+func OpenDisk(name string) (Engine, error) {
+	name, err := filepath.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+	fd, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &disk{
+		name:  name,
+		fd:    fd,
+		index: make(map[string]entry),
+	}
+	if err = e.load(); err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// load 读取数据文件重建索引.
+func (e *disk) load() error {
+	r := bufio.NewReader(e.fd)
+	head := make([]byte, headerSize)
+	var offset int64
+	for {
+		if _, err := io.ReadFull(r, head); err != nil {
+			break
+		}
+		klen := int32(binary.BigEndian.Uint32(head[4:8]))
+		vlen := int32(binary.BigEndian.Uint32(head[8:12]))
+		if klen < 0 || vlen < tombstone {
+			break
+		}
+		size := klen
+		if vlen > 0 {
+			size += vlen
+		}
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			break
+		}
+		crc := crc32.NewIEEE()
+		crc.Write(head[4:])
+		crc.Write(body)
+		if crc.Sum32() != binary.BigEndian.Uint32(head[:4]) {
+			break
+		}
+
+		key := string(body[:klen])
+		if old, ok := e.index[key]; ok {
+			e.garbage += old.recordSize()
+		}
+		n := headerSize + int64(size)
+		if vlen == tombstone {
+			delete(e.index, key)
+			e.garbage += n
+		} else {
+			e.index[key] = entry{offset: offset, size: vlen, klen: klen}
+		}
+		offset += n
+	}
+
+	// 丢弃不完整的记录.
+	e.size = offset
+
+	return e.fd.Truncate(offset)
+}
+
+// Get 获取一个值.
+func (e *disk) Get(key string) ([]byte, bool) {
+	e.RLock()
+	defer e.RUnlock()
+
+	pos, ok := e.index[key]
+	if !ok {
+		return nil, false
+	}
+	b := make([]byte, pos.size)
+	if _, err := e.fd.ReadAt(b, pos.offset+headerSize+int64(pos.klen)); err != nil {
+		return nil, false
+	}
+
+	return b, true
+}
+
+// Set 设置一个值.
+func (e *disk) Set(key string, value []byte) error {
+	e.Lock()
+	defer e.Unlock()
+
+	pos, err := e.write(e.fd, e.size, key, value, int32(len(value)))
+	if err != nil {
+		return err
+	}
+	if old, ok := e.index[key]; ok {
+		e.garbage += old.recordSize()
+	}
+	e.index[key] = pos
+	e.size += pos.recordSize()
+
+	return e.maybeCompact()
+}
+
+// Delete 删除一个值.
+func (e *disk) Delete(key string) error {
+	e.Lock()
+	defer e.Unlock()
+
+	old, ok := e.index[key]
+	if !ok {
+		return nil
+	}
+	if _, err := e.write(e.fd, e.size, key, nil, tombstone); err != nil {
+		return err
+	}
+	delete(e.index, key)
+	n := headerSize + int64(len(key))
+	e.size += n
+	e.garbage += old.recordSize() + n
+
+	return e.maybeCompact()
+}
+
+// Range 遍历所有的key.
+func (e *disk) Range(f func(key string) bool) error {
+	e.RLock()
+	keys := make([]string, 0, len(e.index))
+	for key := range e.index {
+		keys = append(keys, key)
+	}
+	e.RUnlock()
+
+	for _, key := range keys {
+		if !f(key) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// Close 关闭存储引擎, 等待后台压缩退出.
+func (e *disk) Close() error {
+	e.Lock()
+	e.closed = true
+	err := e.fd.Close()
+	e.Unlock()
+	e.wg.Wait()
+
+	return err
+}
+
+// write 在offset位置写入一条记录.
+func (e *disk) write(fd *os.File, offset int64, key string, value []byte, vlen int32) (entry, error) {
+	b := make([]byte, headerSize+len(key)+len(value))
+	binary.BigEndian.PutUint32(b[4:8], uint32(len(key)))
+	binary.BigEndian.PutUint32(b[8:12], uint32(vlen))
+	copy(b[headerSize:], key)
+	copy(b[headerSize+len(key):], value)
+	binary.BigEndian.PutUint32(b[:4], crc32.ChecksumIEEE(b[4:]))
+
+	if _, err := fd.WriteAt(b, offset); err != nil {
+		return entry{}, err
+	}
+
+	return entry{offset: offset, size: vlen, klen: int32(len(key))}, nil
+}
+
+// maybeCompact 无效数据过多时, 在后台重写有效数据到新文件, 调用方需要持有锁.
+func (e *disk) maybeCompact() error {
+	if e.compacting || e.closed || e.garbage < compactSize || e.garbage < e.size/2 {
+		return nil
+	}
+
+	snap := make(map[string]entry, len(e.index))
+	for key, pos := range e.index {
+		snap[key] = pos
+	}
+	e.compacting = true
+	e.wg.Add(1)
+	go e.compact(e.fd, snap)
+
+	return nil
+}
+
+// compact 不持有锁将快照中的数据写入新文件, 再在锁内补写快照之后变化的数据并替换文件.
+// 压缩失败时保留原文件, 之后写入时重试.
+func (e *disk) compact(src *os.File, snap map[string]entry) {
+	defer e.wg.Done()
+
+	// 文件只追加写入, 快照中的记录不会被修改, 压缩期间只有这里替换文件.
+	fd, index, size, err := e.rewrite(src, snap)
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.compacting = false
+	if err == nil && e.closed {
+		err = os.ErrClosed
+	}
+	var garbage int64
+	if err == nil {
+		size, garbage, err = e.catchUp(fd, snap, index, size)
+	}
+	if err == nil {
+		err = os.Rename(e.name+".tmp", e.name)
+	}
+	if err != nil {
+		if fd != nil {
+			fd.Close()
+			os.Remove(e.name + ".tmp")
+		}
+		return
+	}
+
+	e.fd.Close()
+	e.fd = fd
+	e.index = index
+	e.size = size
+	e.garbage = garbage
+}
+
+// rewrite 将快照中的数据从src写入临时文件.
+func (e *disk) rewrite(src *os.File, snap map[string]entry) (*os.File, map[string]entry, int64, error) {
+	fd, err := os.OpenFile(e.name+".tmp", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	index := make(map[string]entry, len(snap))
+	var size int64
+	for key, pos := range snap {
+		if pos, err = e.copyRecord(src, fd, size, key, pos); err != nil {
+			return fd, nil, 0, err
+		}
+		index[key] = pos
+		size += pos.recordSize()
+	}
+
+	return fd, index, size, nil
+}
+
+// catchUp 将快照之后的变化写入新文件, 返回新文件大小与无效数据大小, 调用方需要持有锁.
+func (e *disk) catchUp(fd *os.File, snap, index map[string]entry, size int64) (int64, int64, error) {
+	var garbage int64
+	for key, pos := range index {
+		if _, ok := e.index[key]; ok {
+			continue
+		}
+		// 快照之后删除的数据, 新文件中需要写入删除记录.
+		if _, err := e.write(fd, size, key, nil, tombstone); err != nil {
+			return 0, 0, err
+		}
+		delete(index, key)
+		n := headerSize + int64(len(key))
+		size += n
+		garbage += pos.recordSize() + n
+	}
+	for key, pos := range e.index {
+		if old, ok := snap[key]; ok && old == pos {
+			continue
+		}
+		// 快照之后写入的数据.
+		if old, ok := index[key]; ok {
+			garbage += old.recordSize()
+		}
+		pos, err := e.copyRecord(e.fd, fd, size, key, pos)
+		if err != nil {
+			return 0, 0, err
+		}
+		index[key] = pos
+		size += pos.recordSize()
+	}
+
+	return size, garbage, nil
+}
+
+// copyRecord 将src中的一条记录写入fd的offset位置.
+func (e *disk) copyRecord(src, fd *os.File, offset int64, key string, pos entry) (entry, error) {
+	b := make([]byte, pos.size)
+	if _, err := src.ReadAt(b, pos.offset+headerSize+int64(pos.klen)); err != nil {
+		return entry{}, err
+	}
+
+	return e.write(fd, offset, key, b, pos.size)
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openTest 打开一个压缩阈值很小的磁盘存储引擎.
+func openTest(t *testing.T, name string) *disk {
+	size := compactSize
+	compactSize = 1024
+	t.Cleanup(func() {
+		compactSize = size
+	})
+	e, err := OpenDisk(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e.(*disk)
+}
+
+// waitCompact 等待后台压缩完成.
+func waitCompact(e *disk) {
+	for {
+		e.RLock()
+		compacting := e.compacting
+		e.RUnlock()
+		if !compacting {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// value 测试数据, 每次写入的数据不同.
+func value(key string, n int) []byte {
+
+	return bytes.Repeat([]byte(fmt.Sprintf("%s-%d;", key, n)), 8)
+}
+
+// TestCompactWhileWriting 压缩期间并发写入与删除, 压缩后与重新打开后数据都正确.
+func TestCompactWhileWriting(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "data")
+	e := openTest(t, name)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	want := make(map[string][]byte)
+	var written int64
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for n := 0; n < 200; n++ {
+				key := fmt.Sprintf("job-%d-%d", g, n%10)
+				if n%7 == 0 {
+					if err := e.Delete(key); err != nil {
+						t.Error(err)
+					}
+					mu.Lock()
+					delete(want, key)
+					mu.Unlock()
+					continue
+				}
+				v := value(key, n)
+				if err := e.Set(key, v); err != nil {
+					t.Error(err)
+				}
+				mu.Lock()
+				want[key] = v
+				written += int64(headerSize + len(key) + len(v))
+				mu.Unlock()
+				if got, ok := e.Get(key); !ok || !bytes.Equal(got, v) {
+					t.Errorf("Get(%s) = %q, %v during compaction", key, got, ok)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	waitCompact(e)
+
+	check := func(e *disk) {
+		var n int
+		e.Range(func(key string) bool {
+			n++
+			return true
+		})
+		if n != len(want) {
+			t.Errorf("keys = %d, want %d", n, len(want))
+		}
+		for key, v := range want {
+			if got, ok := e.Get(key); !ok || !bytes.Equal(got, v) {
+				t.Errorf("Get(%s) = %q, %v, want %q", key, got, ok, v)
+			}
+		}
+	}
+	check(e)
+	if e.size >= written/2 {
+		t.Errorf("file size = %d after writing %d bytes, compaction did not run", e.size, written)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	e = openTest(t, name)
+	defer e.Close()
+	check(e)
+	if _, err := os.Stat(name + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left after compaction: %v", err)
+	}
+}
+
+// TestCloseDuringCompact 关闭时等待后台压缩退出, 原文件数据不受影响.
+func TestCloseDuringCompact(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "data")
+	e := openTest(t, name)
+	for n := 0; n < 100; n++ {
+		e.Set("job", value("job", n))
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	e = openTest(t, name)
+	defer e.Close()
+	if got, ok := e.Get("job"); !ok || !bytes.Equal(got, value("job", 99)) {
+		t.Fatalf("Get(job) = %q, %v after reopen", got, ok)
+	}
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Files 内容寻址的文件存储, 文件名为数据的sha1, 相同的数据只保存一份.
+type Files struct {
+	sync.Mutex                // 锁.
+	dir        string         // 存储目录.
+	refs       map[string]int // 文件引用计数.
+}
+
+// OpenFiles 打开一个文件存储目录, 不存在则创建.
+func OpenFiles(dir string) (*Files, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Files{
+		dir:  dir,
+		refs: make(map[string]int),
+	}, nil
+}
+
+// Put 写入数据, 返回数据的引用, 每次调用增加一个引用.
+func (f *Files) Put(data []byte) (string, error) {
+	sum := sha1.Sum(data)
+	ref := hex.EncodeToString(sum[:])
+
+	f.Lock()
+	if f.refs[ref] > 0 {
+		f.refs[ref]++
+		f.Unlock()
+
+		return ref, nil
+	}
+	f.Unlock()
+
+	// 先写入临时文件, 不阻塞其他读写.
+	name := f.path(ref)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return "", err
+	}
+	fd, err := ioutil.TempFile(filepath.Dir(name), ref+".tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = fd.Write(data)
+	if err == nil {
+		err = fd.Close()
+	} else {
+		fd.Close()
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	if err == nil {
+		err = os.Rename(fd.Name(), name)
+	}
+	if err != nil {
+		os.Remove(fd.Name())
+		return "", err
+	}
+	f.refs[ref]++
+
+	return ref, nil
+}
+
+// Open 打开引用对应的文件, 返回文件与大小.
+func (f *Files) Open(ref string) (*os.File, int64, error) {
+	fd, err := os.Open(f.path(ref))
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, 0, err
+	}
+
+	return fd, fi.Size(), nil
+}
+
+// Release 释放一个引用, 没有引用时删除文件.
+// 已经打开的文件在关闭前仍然可以读取.
+func (f *Files) Release(ref string) {
+	f.Lock()
+	defer f.Unlock()
+
+	// 日志重放时引用计数还没有重建, 不删除文件.
+	if f.refs[ref] < 1 {
+		return
+	}
+	if f.refs[ref]--; f.refs[ref] > 0 {
+		return
+	}
+	delete(f.refs, ref)
+	os.Remove(f.path(ref))
+}
+
+// Reset 重置引用计数, 删除没有引用的文件, 用于日志重放之后.
+func (f *Files) Reset(refs map[string]int) error {
+	f.Lock()
+	defer f.Unlock()
+
+	f.refs = refs
+	return filepath.Walk(f.dir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		if _, ok := refs[fi.Name()]; !ok {
+			return os.Remove(name)
+		}
+
+		return nil
+	})
+}
+
+// path 引用对应的文件名, 按前两个字符分目录.
+func (f *Files) path(ref string) string {
+	if len(ref) < 2 {
+		return filepath.Join(f.dir, ref)
+	}
+
+	return filepath.Join(f.dir, ref[:2], ref)
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"sync"
+
+	"../h32"
+)
+
+// BlockSize 数据存储的数组大小.
+const BlockSize = 100
+
+// BucketSize 数据分块存储的桶.
+const BucketSize = 10000
+
+// memory 内存存储引擎, 数据分块存储, 每块有自己的锁.
+type memory struct {
+	buckets []*bucket // 数据块.
+}
+
+// bucket 数据块.
+type bucket struct {
+	sync.RWMutex                   // 读写锁.
+	m            map[string][]byte // 数据.
+}
+
+// NewMemory 新建一个内存存储引擎.
+func NewMemory() Engine {
+	e := &memory{
+		buckets: make([]*bucket, BlockSize),
+	}
+	for k := range e.buckets {
+		e.buckets[k] = &bucket{
+			m: make(map[string][]byte),
+		}
+	}
+
+	return e
+}
+
+// getBucket 获取key所在的数据块.
+func (e *memory) getBucket(key string) *bucket {
+
+	return e.buckets[h32.DefaultHash.GetOffset(key, BlockSize, BucketSize)]
+}
+
+// Get 获取一个值.
+func (e *memory) Get(key string) ([]byte, bool) {
+	b := e.getBucket(key)
+	b.RLock()
+	defer b.RUnlock()
+
+	val, ok := b.m[key]
+
+	return val, ok
+}
+
+// Set 设置一个值.
+func (e *memory) Set(key string, value []byte) error {
+	b := e.getBucket(key)
+	b.Lock()
+	defer b.Unlock()
+
+	b.m[key] = value
+
+	return nil
+}
+
+// Delete 删除一个值.
+func (e *memory) Delete(key string) error {
+	b := e.getBucket(key)
+	b.Lock()
+	defer b.Unlock()
+
+	delete(b.m, key)
+
+	return nil
+}
+
+// Range 遍历所有的key.
+func (e *memory) Range(f func(key string) bool) error {
+	for _, b := range e.buckets {
+		b.RLock()
+		keys := make([]string, 0, len(b.m))
+		for key := range b.m {
+			keys = append(keys, key)
+		}
+		b.RUnlock()
+
+		for _, key := range keys {
+			if !f(key) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// Close 关闭存储引擎.
+func (e *memory) Close() error {
+
+	return nil
+}
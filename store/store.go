@@ -0,0 +1,39 @@
+package store
+
+import (
+	"fmt"
+)
+
+// 存储引擎类型.
+const (
+	// Memory 内存存储引擎.
+	Memory = "memory"
+	// Disk 磁盘存储引擎.
+	Disk = "disk"
+)
+
+// Engine 存储引擎接口, 存储任务数据与任务结果.
+type Engine interface {
+	// Get 获取一个值.
+	Get(key string) ([]byte, bool)
+	// Set 设置一个值, 已经存在则覆盖.
+	Set(key string, value []byte) error
+	// Delete 删除一个值.
+	Delete(key string) error
+	// Range 遍历所有的key, f返回false停止遍历.
+	Range(f func(key string) bool) error
+	// Close 关闭存储引擎.
+	Close() error
+}
+
+// Open 根据类型打开一个存储引擎, name为磁盘存储引擎的文件名.
+func Open(engine, name string) (Engine, error) {
+	switch engine {
+	case "", Memory:
+		return NewMemory(), nil
+	case Disk:
+		return OpenDisk(name)
+	}
+
+	return nil, fmt.Errorf("store: unknown engine %q", engine)
+}
@@ -0,0 +1,105 @@
+package timer
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Item 定时项.
+type Item struct {
+	Key  string    // 数据KEY.
+	Kind uint8     // 定时类型, 由调用方定义.
+	When time.Time // 到期时间.
+}
+
+// itemHeap 按到期时间排序的最小堆.
+type itemHeap []*Item
+
+func (h itemHeap) Len() int            { return len(h) }
+func (h itemHeap) Less(i, j int) bool  { return h[i].When.Before(h[j].When) }
+func (h itemHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *itemHeap) Push(x interface{}) { *h = append(*h, x.(*Item)) }
+
+func (h *itemHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	itm := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+
+	return itm
+}
+
+// Timer 定时器, 到期后回调fire, 添加与到期都是O(log n).
+// 定时项不支持删除，回调方需要自行判定定时项是否已经失效.
+type Timer struct {
+	sync.Mutex                  // 锁.
+	items      itemHeap         // 定时项.
+	wake       chan interface{} // 新增更早的定时项时，唤醒等待.
+	fire       func(itm *Item)  // 到期回调函数.
+}
+
+// New 新建一个定时器.
+func New(fire func(itm *Item)) *Timer {
+	t := &Timer{
+		items: make(itemHeap, 0),
+		wake:  make(chan interface{}, 1),
+		fire:  fire,
+	}
+	go t.run()
+
+	return t
+}
+
+// Add 添加一个定时项.
+func (t *Timer) Add(key string, kind uint8, when time.Time) {
+	t.Lock()
+	defer t.Unlock()
+
+	itm := &Item{
+		Key:  key,
+		Kind: kind,
+		When: when,
+	}
+	heap.Push(&t.items, itm)
+	if t.items[0] == itm {
+		select {
+		case t.wake <- nil:
+		default:
+		}
+	}
+}
+
+// run 等待定时项到期.
+func (t *Timer) run() {
+	for {
+		var due []*Item
+		wait := time.Hour
+
+		t.Lock()
+		now := time.Now()
+		for len(t.items) > 0 {
+			if d := t.items[0].When.Sub(now); d > 0 {
+				wait = d
+				break
+			}
+			due = append(due, heap.Pop(&t.items).(*Item))
+		}
+		t.Unlock()
+
+		for _, itm := range due {
+			t.fire(itm)
+		}
+		if len(due) > 0 {
+			continue
+		}
+
+		tick := time.NewTimer(wait)
+		select {
+		case <-tick.C:
+		case <-t.wake:
+		}
+		tick.Stop()
+	}
+}
@@ -0,0 +1,91 @@
+package timer
+
+import (
+	"testing"
+	"time"
+)
+
+// collect 等待n个定时项到期, 返回到期顺序.
+func collect(t *testing.T, fired chan *Item, n int) []*Item {
+	items := make([]*Item, 0, n)
+	for len(items) < n {
+		select {
+		case itm := <-fired:
+			items = append(items, itm)
+		case <-time.After(time.Second):
+			t.Fatalf("%d of %d items fired", len(items), n)
+		}
+	}
+
+	return items
+}
+
+// TestTimerOrder 定时项按到期时间先后回调, 与添加顺序无关.
+func TestTimerOrder(t *testing.T) {
+	fired := make(chan *Item, 10)
+	tm := New(func(itm *Item) {
+		fired <- itm
+	})
+	now := time.Now()
+	for _, c := range []struct {
+		key   string
+		after time.Duration
+	}{
+		{"d", 40 * time.Millisecond},
+		{"b", 20 * time.Millisecond},
+		{"e", 50 * time.Millisecond},
+		{"a", 10 * time.Millisecond},
+		{"c", 30 * time.Millisecond},
+		{"past", -time.Second},
+	} {
+		tm.Add(c.key, 1, now.Add(c.after))
+	}
+
+	var got string
+	for _, itm := range collect(t, fired, 6) {
+		if time.Now().Before(itm.When) {
+			t.Errorf("%s fired before it was due", itm.Key)
+		}
+		got += itm.Key + " "
+	}
+	if want := "past a b c d e "; got != want {
+		t.Fatalf("fired order = %q, want %q", got, want)
+	}
+}
+
+// TestTimerEarlyWake 添加比当前等待更早到期的定时项时立即重新计算等待时间.
+func TestTimerEarlyWake(t *testing.T) {
+	fired := make(chan *Item, 2)
+	tm := New(func(itm *Item) {
+		fired <- itm
+	})
+	tm.Add("late", 1, time.Now().Add(time.Hour))
+	// 等待定时器开始等待最早的定时项.
+	time.Sleep(10 * time.Millisecond)
+	start := time.Now()
+	tm.Add("early", 2, start.Add(20*time.Millisecond))
+
+	itm := collect(t, fired, 1)[0]
+	if itm.Key != "early" || itm.Kind != 2 {
+		t.Fatalf("fired %s kind %d, want early kind 2", itm.Key, itm.Kind)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("early item fired after %v", d)
+	}
+}
+
+// TestTimerSameKey 同一个KEY的多个定时项都会回调, 由回调方判定是否失效.
+func TestTimerSameKey(t *testing.T) {
+	fired := make(chan *Item, 2)
+	tm := New(func(itm *Item) {
+		fired <- itm
+	})
+	now := time.Now()
+	tm.Add("job", 1, now.Add(20*time.Millisecond))
+	tm.Add("job", 1, now.Add(10*time.Millisecond))
+
+	items := collect(t, fired, 2)
+	if !items[0].When.Equal(now.Add(10*time.Millisecond)) || !items[1].When.Equal(now.Add(20*time.Millisecond)) {
+		t.Fatalf("fired at %v, %v, want earlier first", items[0].When, items[1].When)
+	}
+}